@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/sbekti/broadcastd/broadcast"
+	"github.com/sbekti/broadcastd/instagram"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// login logs in to an account with a password typed on the terminal,
+// answers a challenge if Instagram asks for one, and saves the token.
+func login(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	fs.Usage = commandUsage(fs, "login <account>")
+	name := parseAccountArgs(fs, args)
+	c := loadAccountConfig(*configPath, name)
+
+	password, err := readPassword(fmt.Sprintf("Password for %s (empty to use the configured one): ", name))
+	if err != nil {
+		fatalf("login: %v", err)
+	}
+	if password == "" {
+		password = c.Credentials(name).Password
+	}
+	if password == "" {
+		fatalf("login: %s has no password configured", name)
+	}
+
+	i := instagram.New(name, password, c.InstagramOptions(name)...)
+	if err := i.Login(); err != nil {
+		challengeErr, ok := err.(*instagram.ChallengeError)
+		if !ok {
+			fatalf("login: %s: unable to login: %v", name, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Instagram requires a challenge: %s\n", challengeErr.Message)
+		if err := i.Challenge.Process(challengeErr.Challenge.APIPath); err != nil {
+			fatalf("login: %s: unable to process challenge: %v", name, err)
+		}
+		if contact := i.Challenge.StepData.ContactPoint; contact != "" {
+			fmt.Fprintf(os.Stderr, "A security code was sent to %s.\n", contact)
+		}
+
+		code, err := readLine("Security code: ")
+		if err != nil {
+			fatalf("login: %v", err)
+		}
+		if err := i.Challenge.SendSecurityCode(code); err != nil {
+			fatalf("login: %s: unable to send security code: %v", name, err)
+		}
+		i.Account = i.Challenge.LoggedInUser
+	}
+
+	t, err := instagram.ExportToString(i)
+	if err != nil {
+		fatalf("login: %s: unable to export token: %v", name, err)
+	}
+	if err := c.SaveToken(name, t); err != nil {
+		fatalf("login: %s: unable to save token: %v", name, err)
+	}
+	fmt.Printf("logged in to %s, the token is used from the next login of the daemon\n", name)
+}
+
+// token exports, imports or revokes the token of an account, e.g. to move
+// a logged in session to another host.
+func token(args []string) {
+	if len(args) == 0 {
+		fatalf("usage: broadcastd token export|import|revoke <account> [flags]")
+	}
+
+	switch args[0] {
+	case "export":
+		tokenExport(args[1:])
+	case "import":
+		tokenImport(args[1:])
+	case "revoke":
+		tokenRevoke(args[1:])
+	default:
+		fatalf("token: unknown command %q, expected export, import or revoke", args[0])
+	}
+}
+
+func tokenExport(args []string) {
+	fs := flag.NewFlagSet("token export", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	fs.Usage = commandUsage(fs, "token export <account>")
+	name := parseAccountArgs(fs, args)
+	c := loadAccountConfig(*configPath, name)
+
+	t := c.Credentials(name).Token
+	if t == "" {
+		fatalf("token: %s has no token, log in first", name)
+	}
+	fmt.Println(t)
+}
+
+func tokenImport(args []string) {
+	fs := flag.NewFlagSet("token import", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	verify := fs.Bool("verify", true, "check that the token is still logged in before saving it")
+	fs.Usage = commandUsage(fs, "token import <account>")
+	name := parseAccountArgs(fs, args)
+	c := loadAccountConfig(*configPath, name)
+
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fatalf("token: unable to read token: %v", err)
+	}
+	t := strings.TrimSpace(string(b))
+	if t == "" {
+		fatalf("token: no token given on standard input")
+	}
+
+	if *verify {
+		i, err := instagram.ImportFromString(t, c.InstagramOptions(name)...)
+		if err != nil {
+			fatalf("token: %s: unable to login by token: %v", name, err)
+		}
+		if username := i.Account.Username; username != "" && username != name {
+			fatalf("token: the token belongs to %s, not %s", username, name)
+		}
+	}
+
+	if err := c.SaveToken(name, t); err != nil {
+		fatalf("token: %s: unable to save token: %v", name, err)
+	}
+	fmt.Printf("imported the token of %s\n", name)
+}
+
+func tokenRevoke(args []string) {
+	fs := flag.NewFlagSet("token revoke", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	fs.Usage = commandUsage(fs, "token revoke <account>")
+	name := parseAccountArgs(fs, args)
+	c := loadAccountConfig(*configPath, name)
+
+	t := c.Credentials(name).Token
+	if t == "" {
+		fatalf("token: %s has no token", name)
+	}
+
+	// The token is removed even if the session has already expired.
+	i, err := instagram.ImportFromString(t, c.InstagramOptions(name)...)
+	if err == nil {
+		_, err = i.Logout()
+	}
+	if err != nil {
+		log.Warnf("token: %s: unable to log out, removing the token anyway: %v", name, err)
+	}
+
+	if err := c.SaveToken(name, ""); err != nil {
+		fatalf("token: %s: unable to remove token: %v", name, err)
+	}
+	if c.Credentials(name).Token != "" {
+		fatalf("token: %s: the token is still in %s, remove it from there", name, *configPath)
+	}
+	fmt.Printf("revoked the token of %s\n", name)
+}
+
+// loadAccountConfig loads the config for a command that manages a single
+// account. Problems that do not concern the account, such as a missing
+// encoder on the host that logs in, are only reported as warnings.
+func loadAccountConfig(configPath string, name string) *broadcast.Config {
+	c, err := broadcast.LoadConfig(configPath)
+	if verr, ok := err.(*broadcast.ValidationError); ok {
+		for _, problem := range verr.Problems {
+			if strings.HasPrefix(problem, "credential_store") || strings.HasPrefix(problem, "accounts."+name+".") {
+				fatalf("%v", err)
+			}
+			log.Warnf("config: %s", problem)
+		}
+	} else if err != nil {
+		fatalf("%v", err)
+	}
+
+	if _, ok := c.Accounts[name]; !ok {
+		fatalf("config: account %s does not exist in %s", name, configPath)
+	}
+	return c
+}
+
+// parseAccountArgs parses the flags of a command that takes an account
+// name, which may be given before or after the flags, and returns the name.
+func parseAccountArgs(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	name := fs.Arg(0)
+
+	fs.Parse(fs.Args()[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return name
+}
+
+func commandUsage(fs *flag.FlagSet, command string) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: broadcastd %s [flags]\n", command)
+		fs.PrintDefaults()
+	}
+}
+
+// readPassword reads a password from the terminal without echoing it, or a
+// line from standard input if it is not a terminal.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return readLine(prompt)
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return string(b), err
+}
+
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package broadcast
+
+import "syscall"
+
+// accessWrite is W_OK of access(2).
+const accessWrite = 0x2
+
+// accessWritable reports whether the process can write to path.
+func accessWritable(path string) error {
+	return syscall.Access(path, accessWrite)
+}
@@ -0,0 +1,19 @@
+package broadcast
+
+import (
+	"fmt"
+	"os"
+)
+
+// accessWritable reports whether path is writable. Windows has no
+// access(2), so only the read-only attribute is checked.
+func accessWritable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0200 == 0 {
+		return fmt.Errorf("read-only")
+	}
+	return nil
+}
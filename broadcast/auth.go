@@ -0,0 +1,182 @@
+package broadcast
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookieName = "broadcastd_session"
+	sessionTokenSize  = 32
+)
+
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// sessionStore keeps dashboard sessions in memory, so every session ends
+// when the daemon restarts.
+type sessionStore struct {
+	mux      sync.Mutex
+	ttl      time.Duration
+	sessions map[string]session
+}
+
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{
+		ttl:      ttl,
+		sessions: make(map[string]session),
+	}
+}
+
+// setTTL changes the lifetime of new sessions.
+func (s *sessionStore) setTTL(ttl time.Duration) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.ttl = ttl
+}
+
+func (s *sessionStore) create(username string) (string, error) {
+	b := make([]byte, sessionTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	for t, session := range s.sessions {
+		if now.After(session.expires) {
+			delete(s.sessions, t)
+		}
+	}
+
+	s.sessions[token] = session{
+		username: username,
+		expires:  now.Add(s.ttl),
+	}
+	return token, nil
+}
+
+func (s *sessionStore) valid(token string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	session, ok := s.sessions[token]
+	if !ok {
+		return false
+	}
+	if time.Now().After(session.expires) {
+		delete(s.sessions, token)
+		return false
+	}
+	return true
+}
+
+func (s *sessionStore) delete(token string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.sessions, token)
+}
+
+func containsToken(tokens []string, token string) bool {
+	if token == "" {
+		return false
+	}
+
+	found := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+func bearerToken(c echo.Context) string {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+func (b *Broadcast) hasSession(c echo.Context) bool {
+	cookie, err := c.Cookie(sessionCookieName)
+	if err != nil {
+		return false
+	}
+	return b.sessions.valid(cookie.Value)
+}
+
+// dashboardAuth requires a dashboard session, redirecting to the login
+// page otherwise.
+func dashboardAuth(b *Broadcast) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !b.Config().Auth.Enabled || b.hasSession(c) {
+				return next(c)
+			}
+			return c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request().URL.RequestURI()))
+		}
+	}
+}
+
+// apiAuth requires an API bearer token or a dashboard session.
+func apiAuth(b *Broadcast) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !b.Config().Auth.Enabled ||
+				containsToken(b.Config().Auth.APITokens, bearerToken(c)) ||
+				b.hasSession(c) {
+				return next(c)
+			}
+			return c.JSON(http.StatusUnauthorized, apiRes{
+				Status: "error",
+				Error:  "unauthorized",
+			})
+		}
+	}
+}
+
+// readOnlyAuth requires a read-only token, an API token or a dashboard
+// session. The token may be passed in the query string, since browsers
+// cannot set headers on WebSocket connections or embedded pages.
+func readOnlyAuth(b *Broadcast) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			auth := b.Config().Auth
+			if !auth.Enabled || b.hasSession(c) {
+				return next(c)
+			}
+
+			token := c.QueryParam("token")
+			if token == "" {
+				token = bearerToken(c)
+			}
+			if containsToken(auth.ReadOnlyTokens, token) || containsToken(auth.APITokens, token) {
+				return next(c)
+			}
+			return c.String(http.StatusUnauthorized, "unauthorized")
+		}
+	}
+}
+
+// safeRedirect only allows redirects to paths on this server.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
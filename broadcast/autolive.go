@@ -0,0 +1,99 @@
+package broadcast
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	probeTimeout = 10 * time.Second
+)
+
+// watchInput starts streams once the input has been live for the start
+// delay, and stops them once it has been gone for the stop delay. Streams
+// are started at most once per input session, so stopping them by hand
+// while the input is still live is respected.
+func (b *Broadcast) watchInput(ctx context.Context) {
+	c := b.Config().AutoLive
+	startDelay := time.Duration(c.StartDelay) * time.Second
+	stopDelay := time.Duration(c.StopDelay) * time.Second
+
+	ticker := time.NewTicker(time.Duration(c.ProbeInterval) * time.Second)
+	defer ticker.Stop()
+
+	var liveSince, goneSince time.Time
+	handled := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		live := b.inputLive(ctx)
+		streaming := b.isStreaming()
+
+		if live {
+			goneSince = time.Time{}
+			if liveSince.IsZero() {
+				log.Infof("broadcast: auto live: input is live")
+				liveSince = now
+			}
+		} else {
+			liveSince = time.Time{}
+			if goneSince.IsZero() {
+				log.Infof("broadcast: auto live: input is gone")
+				goneSince = now
+			}
+		}
+
+		switch {
+		case live && streaming:
+			handled = true
+		case live && !streaming && !handled && now.Sub(liveSince) >= startDelay:
+			handled = true
+			log.Infof("broadcast: auto live: starting streams")
+			if err := b.StartStreams(); err != nil {
+				log.Errorf("broadcast: auto live: unable to start streams: %v", err)
+			}
+		case !live && streaming && now.Sub(goneSince) >= stopDelay:
+			log.Infof("broadcast: auto live: stopping streams")
+			if err := b.StopStreams(); err != nil {
+				log.Errorf("broadcast: auto live: unable to stop streams: %v", err)
+			}
+		case !live:
+			handled = false
+		}
+	}
+}
+
+// inputLive reports whether the input is available, either from the state
+// of the built-in ingest server or by probing the input URL.
+func (b *Broadcast) inputLive(ctx context.Context) bool {
+	if b.ingest != nil {
+		return b.ingest.State().Publishing
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	args := []string{
+		"-v", "error",
+		"-rw_timeout", strconv.FormatInt(probeTimeout.Microseconds(), 10),
+		"-show_entries", "stream=codec_type",
+		"-of", "csv=p=0",
+		b.Config().InputURL,
+	}
+	out, err := exec.CommandContext(ctx, b.Config().AutoLive.ProbeCommand, args...).Output()
+	if err != nil {
+		log.Debugf("broadcast: auto live: probe failed: %v", err)
+		return false
+	}
+	return strings.TrimSpace(string(out)) != ""
+}
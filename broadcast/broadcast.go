@@ -8,13 +8,18 @@ import (
 	"fmt"
 	"github.com/ReneKroon/ttlcache/v2"
 	"github.com/sbekti/broadcastd/instagram"
+	"github.com/sbekti/broadcastd/rtmp"
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/net/websocket"
 	"golang.org/x/sync/errgroup"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,49 +30,109 @@ const (
 )
 
 type Broadcast struct {
-	streaming    bool
-	streamingMux sync.RWMutex
+	// autoLive is set before Start, so that Stop can cancel it from
+	// another goroutine.
+	autoLive       context.Context
+	cancelAutoLive context.CancelFunc
 
-	config  *Config
-	server  *Server
-	streams map[string]*Stream
+	config    *Config
+	configMux sync.RWMutex
+	reloadMux sync.Mutex
+
+	server *Server
+	ingest *rtmp.Server
+	relay  *Relay
+
+	streams    map[string]*Stream
+	streamsMux sync.RWMutex
 
 	connections    map[*websocket.Conn]struct{}
 	connectionsMux sync.RWMutex
 
+	statusSubscribers    map[chan StatusEvent]struct{}
+	statusSubscribersMux sync.RWMutex
+
+	sessions *sessionStore
+	viewers  *viewerSeries
+	metrics  *metrics
+	recovery *recoveryState
+
 	commentsCache  *ttlcache.Cache
 	recentComments *list.List
 }
 
 func NewBroadcast(c *Config) *Broadcast {
+	b := newBroadcast(c)
+	b.server = NewServer(b, c.BindIP, c.BindPort)
+	return b
+}
+
+// newBroadcast creates a broadcast and its streams without the HTTP
+// server.
+func newBroadcast(c *Config) *Broadcast {
 	cache := ttlcache.NewCache()
 	cache.SetTTL(cacheTTL)
 
 	b := &Broadcast{
-		config:         c,
-		streams:        make(map[string]*Stream),
-		connections:    make(map[*websocket.Conn]struct{}),
-		commentsCache:  cache,
-		recentComments: list.New(),
+		config:            c,
+		streams:           make(map[string]*Stream),
+		connections:       make(map[*websocket.Conn]struct{}),
+		statusSubscribers: make(map[chan StatusEvent]struct{}),
+		commentsCache:     cache,
+		recentComments:    list.New(),
+		sessions:          newSessionStore(time.Duration(c.Auth.SessionTTL) * time.Hour),
+		viewers:           newViewerSeries(time.Duration(c.PollInterval) * time.Second),
+		recovery:          newRecoveryState(),
 	}
-	b.server = NewServer(b, c.BindIP, c.BindPort)
+	b.metrics = newMetrics(b)
+	b.autoLive, b.cancelAutoLive = context.WithCancel(context.Background())
+
+	if c.Ingest.Enabled {
+		addr := net.JoinHostPort(c.Ingest.BindIP, strconv.Itoa(c.Ingest.BindPort))
+		b.ingest = rtmp.NewServer(addr, c.Ingest.App, c.Ingest.StreamKey)
+	}
+	b.relay = NewRelay(c, b.ingest)
 
 	for name := range c.Accounts {
 		b.streams[name] = NewStream(name, b.config, b)
 	}
 
+	for name, destination := range c.Destinations {
+		b.streams[name] = NewDestinationStream(name, destination, b.config, b)
+	}
+
 	return b
 }
 
 func (b *Broadcast) Start() error {
+	// Recovering logs in to Instagram, which can take a while, so the API
+	// is served meanwhile.
+	if broadcasts := b.loadRecovery(); len(broadcasts) > 0 {
+		go b.recover(broadcasts)
+	}
+
+	if b.ingest != nil {
+		go func() {
+			if err := b.ingest.ListenAndServe(); err != nil && err != rtmp.ErrServerClosed {
+				log.Errorf("broadcast: ingest server error: %v", err)
+			}
+		}()
+	}
+
+	if b.Config().AutoLive.Enabled {
+		go b.watchInput(b.autoLive)
+	}
+
 	return b.server.Start()
 }
 
 func (b *Broadcast) Stop() error {
+	b.cancelAutoLive()
+
 	g, _ := errgroup.WithContext(context.Background())
 
 	g.Go(func() error {
-		if b.streaming {
+		if b.isStreaming() {
 			return b.StopStreams()
 		}
 		return nil
@@ -77,59 +142,160 @@ func (b *Broadcast) Stop() error {
 		return b.server.Shutdown()
 	})
 
+	if b.ingest != nil {
+		g.Go(func() error {
+			return b.ingest.Close()
+		})
+	}
+
 	return g.Wait()
 }
 
-func (b *Broadcast) StartStreams() error {
-	b.streamingMux.Lock()
-	defer b.streamingMux.Unlock()
+// Shutdown stops the broadcast like Stop, which ends every broadcast and
+// runs the post-live actions, but gives up once ctx is done, reporting the
+// streams that have not stopped yet. The recovery state is written before
+// it gives up and is not changed afterwards, so the process can exit.
+func (b *Broadcast) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Stop()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if err := b.recovery.freeze(b.Config().Recovery.StateFile); err != nil {
+			log.Errorf("broadcast: unable to write recovery state: %v", err)
+		}
 
-	if b.streaming {
-		return fmt.Errorf("broadcast: streams are already started")
+		var names []string
+		for _, stream := range b.streamList() {
+			if stream.IsStreaming() {
+				names = append(names, stream.name)
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("broadcast: %v while stopping", ctx.Err())
+		}
+		return fmt.Errorf("broadcast: %v while stopping %s", ctx.Err(), strings.Join(names, ", "))
 	}
+}
 
-	g, _ := errgroup.WithContext(context.Background())
+// Config returns the current config, which is replaced when it is
+// reloaded.
+func (b *Broadcast) Config() *Config {
+	b.configMux.RLock()
+	defer b.configMux.RUnlock()
+	return b.config
+}
 
+func (b *Broadcast) stream(name string) (*Stream, bool) {
+	b.streamsMux.RLock()
+	defer b.streamsMux.RUnlock()
+	stream, ok := b.streams[name]
+	return stream, ok
+}
+
+// streamList returns the streams sorted by name.
+func (b *Broadcast) streamList() []*Stream {
+	b.streamsMux.RLock()
+	defer b.streamsMux.RUnlock()
+
+	streams := make([]*Stream, 0, len(b.streams))
 	for _, stream := range b.streams {
-		stream := stream
-		g.Go(func() error {
-			return stream.Start()
-		})
+		streams = append(streams, stream)
 	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].name < streams[j].name
+	})
+	return streams
+}
 
-	err := g.Wait()
-	if err != nil {
-		return err
+// isStreaming reports whether any stream is started.
+func (b *Broadcast) isStreaming() bool {
+	for _, stream := range b.streamList() {
+		if stream.IsStreaming() {
+			return true
+		}
 	}
+	return false
+}
 
-	b.streaming = true
-	return nil
+// StartStreams starts every stream that is not started yet.
+func (b *Broadcast) StartStreams() error {
+	return b.forEachStream(false, func(stream *Stream) error {
+		return stream.Start()
+	})
 }
 
+// StopStreams stops every stream that is started.
 func (b *Broadcast) StopStreams() error {
-	b.streamingMux.Lock()
-	defer b.streamingMux.Unlock()
-
-	if !b.streaming {
-		return fmt.Errorf("broadcast: streams are not started")
-	}
+	return b.forEachStream(true, func(stream *Stream) error {
+		return stream.Stop()
+	})
+}
 
+func (b *Broadcast) forEachStream(streaming bool, fn func(stream *Stream) error) error {
 	g, _ := errgroup.WithContext(context.Background())
 
-	for _, stream := range b.streams {
+	found := false
+	for _, stream := range b.streamList() {
+		if stream.IsStreaming() != streaming {
+			continue
+		}
+		found = true
+
 		stream := stream
 		g.Go(func() error {
-			return stream.Stop()
+			return fn(stream)
 		})
 	}
 
-	err := g.Wait()
-	if err != nil {
-		return err
+	if !found {
+		if streaming {
+			return fmt.Errorf("broadcast: streams are not started")
+		}
+		return fmt.Errorf("broadcast: streams are already started")
 	}
 
-	b.streaming = false
-	return nil
+	return g.Wait()
+}
+
+func (b *Broadcast) StartStream(name string) error {
+	stream, ok := b.stream(name)
+	if !ok {
+		return fmt.Errorf("broadcast: stream %s does not exist", name)
+	}
+	return stream.Start()
+}
+
+func (b *Broadcast) StopStream(name string) error {
+	stream, ok := b.stream(name)
+	if !ok {
+		return fmt.Errorf("broadcast: stream %s does not exist", name)
+	}
+	return stream.Stop()
+}
+
+// StreamInfos returns a snapshot of every stream, sorted by name.
+func (b *Broadcast) StreamInfos() []StreamInfo {
+	streams := b.streamList()
+	infos := make([]StreamInfo, 0, len(streams))
+	for _, stream := range streams {
+		infos = append(infos, stream.Info())
+	}
+	return infos
+}
+
+// IngestState returns the state of the built-in ingest server, or nil if
+// it is disabled.
+func (b *Broadcast) IngestState() *rtmp.State {
+	if b.ingest == nil {
+		return nil
+	}
+	state := b.ingest.State()
+	return &state
 }
 
 func (b *Broadcast) broadcastComment(streamName string, broadcastID int, comment instagram.LiveComment) error {
@@ -144,7 +310,7 @@ func (b *Broadcast) broadcastComment(streamName string, broadcastID int, comment
 		return nil
 	}
 
-	if b.config.Logging.Enabled {
+	if b.Config().Logging.Enabled {
 		err := b.writeCommentLog(int64(comment.CreatedAt), broadcastID, streamName, comment.User.Username, comment.Text)
 		if err != nil {
 			return err
@@ -155,6 +321,7 @@ func (b *Broadcast) broadcastComment(streamName string, broadcastID int, comment
 	if err := b.commentsCache.Set(cacheKey, true); err != nil {
 		return err
 	}
+	b.metrics.commentsReceived.WithLabelValues(streamName).Inc()
 
 	if b.recentComments.Len() > maxRecentComments {
 		b.recentComments.Remove(b.recentComments.Front())
@@ -175,7 +342,7 @@ func (b *Broadcast) broadcastComment(streamName string, broadcastID int, comment
 func (b *Broadcast) writeViewerLog(timestamp int64, broadcastID int, username string,
 	viewerCount int, totalUniqueViewerCount int) error {
 
-	logDirectory := b.config.Logging.LogDirectory
+	logDirectory := b.Config().Logging.LogDirectory
 	if err := os.MkdirAll(logDirectory, os.ModePerm); err != nil {
 		return err
 	}
@@ -210,7 +377,7 @@ func (b *Broadcast) writeViewerLog(timestamp int64, broadcastID int, username st
 func (b *Broadcast) writeCommentLog(timestamp int64, broadcastID int, username string,
 	commenter string, comment string) error {
 
-	logDirectory := b.config.Logging.LogDirectory
+	logDirectory := b.Config().Logging.LogDirectory
 	if err := os.MkdirAll(filepath.Dir(logDirectory), os.ModePerm); err != nil {
 		return err
 	}
@@ -245,7 +412,7 @@ func (b *Broadcast) writeCommentLog(timestamp int64, broadcastID int, username s
 func (b *Broadcast) writeFinalViewerList(broadcastID int, username string,
 	viewerList *instagram.LiveGetFinalViewerListResponse) error {
 
-	logDirectory := b.config.Logging.LogDirectory
+	logDirectory := b.Config().Logging.LogDirectory
 	if err := os.MkdirAll(filepath.Dir(logDirectory), os.ModePerm); err != nil {
 		return err
 	}
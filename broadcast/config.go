@@ -1,9 +1,16 @@
 package broadcast
 
 import (
+	"fmt"
+	"github.com/sbekti/broadcastd/instagram"
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -12,6 +19,20 @@ const (
 	defaultHeight          = 1280
 	defaultWidth           = 720
 	defaultPollInterval    = 2
+	defaultIngestPort      = 1935
+	defaultIngestApp       = "live"
+	defaultProbeCommand    = "ffprobe"
+	defaultProbeInterval   = 5
+	defaultStartDelay      = 10
+	defaultStopDelay       = 60
+	defaultSessionTTL      = 24
+	defaultBindPort        = 3000
+	defaultMaxAttempts     = 3
+	defaultInitialBackoff  = 500
+	defaultMaxBackoff      = 10000
+	defaultMultiplier      = 2
+	defaultJitter          = 0.2
+	defaultShutdownTimeout = 60
 )
 
 var (
@@ -24,20 +45,25 @@ var (
 		"-max_muxing_queue_size", "1024",
 		"-loglevel", "error",
 	}
+	encoderOutputArgs = []string{
+		"-c", "copy",
+		"-loglevel", "error",
+	}
 )
 
 type Encoder struct {
-	Command string   `yaml:"command"`
-	Args    []string `yaml:"args"`
-	Height  int      `yaml:"height"`
-	Width   int      `yaml:"width"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	OutputArgs []string `yaml:"output_args"`
+	Height     int      `yaml:"height"`
+	Width      int      `yaml:"width"`
 }
 
 type IGTV struct {
-	Enabled     bool   `yaml:"enabled"`
-	MinDuration int    `yaml:"min_duration"`
-	ShareToFeed bool   `yaml:"share_to_feed"`
-	Description string `yaml:"description"`
+	Enabled     bool   `yaml:"enabled" json:"enabled"`
+	MinDuration int    `yaml:"min_duration" json:"min_duration"`
+	ShareToFeed bool   `yaml:"share_to_feed" json:"share_to_feed"`
+	Description string `yaml:"description" json:"description"`
 }
 
 type Logging struct {
@@ -46,41 +72,199 @@ type Logging struct {
 }
 
 type Announcement struct {
-	Message    string `yaml:"message"`
-	MinuteMark int    `yaml:"minute_mark"`
+	Message    string `yaml:"message" json:"message"`
+	MinuteMark int    `yaml:"minute_mark" json:"minute_mark"`
+}
+
+type Ingest struct {
+	Enabled   bool   `yaml:"enabled"`
+	BindIP    string `yaml:"bind_ip"`
+	BindPort  int    `yaml:"bind_port"`
+	App       string `yaml:"app"`
+	StreamKey string `yaml:"stream_key"`
+}
+
+type AutoLive struct {
+	Enabled       bool   `yaml:"enabled"`
+	StartDelay    int    `yaml:"start_delay"`
+	StopDelay     int    `yaml:"stop_delay"`
+	ProbeInterval int    `yaml:"probe_interval"`
+	ProbeCommand  string `yaml:"probe_command"`
+}
+
+type Auth struct {
+	Enabled        bool              `yaml:"enabled"`
+	Users          map[string]string `yaml:"users"`
+	APITokens      []string          `yaml:"api_tokens"`
+	ReadOnlyTokens []string          `yaml:"read_only_tokens"`
+	SessionTTL     int               `yaml:"session_ttl"`
+}
+
+// CredentialStore keeps account passwords and tokens in an encrypted file
+// instead of the config file. It is enabled when Path is set.
+type CredentialStore struct {
+	Path    string `yaml:"path"`
+	KeyFile string `yaml:"key_file"`
+}
+
+type Recovery struct {
+	StateFile string `yaml:"state_file"`
+	Action    string `yaml:"action"`
+}
+
+type Instagram struct {
+	BaseURL    string     `yaml:"base_url"`
+	Retry      Retry      `yaml:"retry"`
+	RateLimits RateLimits `yaml:"rate_limits"`
+}
+
+// RateLimit is a token bucket that allows Burst requests at once and is
+// refilled at PerMinute requests per minute.
+type RateLimit struct {
+	PerMinute float64 `yaml:"per_minute"`
+	Burst     int     `yaml:"burst"`
+}
+
+// RateLimits override the built-in request budgets of every account.
+// Endpoints are keyed by path, e.g. '/live/{id}/get_comment/'.
+type RateLimits struct {
+	Default   *RateLimit           `yaml:"default"`
+	Endpoints map[string]RateLimit `yaml:"endpoints"`
+}
+
+// Retry configures how heartbeats, comment polling and other idempotent
+// Instagram API calls are retried. Backoffs are in milliseconds.
+type Retry struct {
+	MaxAttempts    int     `yaml:"max_attempts"`
+	InitialBackoff int     `yaml:"initial_backoff"`
+	MaxBackoff     int     `yaml:"max_backoff"`
+	Multiplier     float64 `yaml:"multiplier"`
+	Jitter         float64 `yaml:"jitter"`
 }
 
 type Config struct {
-	InputURL     string              `yaml:"input_url"`
-	Accounts     map[string]*Account `yaml:"accounts"`
-	BindIP       string              `yaml:"bind_ip"`
-	BindPort     int                 `yaml:"bind_port"`
-	Encoder      Encoder             `yaml:"encoder"`
-	Title        string              `yaml:"title"`
-	IGTV         IGTV                `yaml:"igtv"`
-	Notify       bool                `yaml:"notify"`
-	LogLevel     string              `yaml:"log_level"`
-	PollInterval int                 `yaml:"poll_interval"`
-	Logging      Logging             `yaml:"logging"`
-	Announcement Announcement        `yaml:"announcement"`
-	path         string
+	InputURL        string                  `yaml:"input_url"`
+	Ingest          Ingest                  `yaml:"ingest"`
+	AutoLive        AutoLive                `yaml:"auto_live"`
+	Accounts        map[string]*Account     `yaml:"accounts"`
+	Destinations    map[string]*Destination `yaml:"destinations"`
+	BindIP          string                  `yaml:"bind_ip"`
+	BindPort        int                     `yaml:"bind_port"`
+	Auth            Auth                    `yaml:"auth"`
+	Encoder         Encoder                 `yaml:"encoder"`
+	Title           string                  `yaml:"title"`
+	IGTV            IGTV                    `yaml:"igtv"`
+	Notify          bool                    `yaml:"notify"`
+	LogLevel        string                  `yaml:"log_level"`
+	PollInterval    int                     `yaml:"poll_interval"`
+	ShutdownTimeout int                     `yaml:"shutdown_timeout"`
+	Logging         Logging                 `yaml:"logging"`
+	Announcement    Announcement            `yaml:"announcement"`
+	Instagram       Instagram               `yaml:"instagram"`
+	CredentialStore CredentialStore         `yaml:"credential_store"`
+	Recovery        Recovery                `yaml:"recovery"`
+	path            string
+	store           *credentialStore
+	// tokens are the tokens saved since the config was loaded. The config
+	// is otherwise read-only once loaded, so that it can be shared.
+	tokens *savedTokens
+}
+
+type savedTokens struct {
+	mux    sync.RWMutex
+	tokens map[string]string
+}
+
+func (t *savedTokens) get(name string) (string, bool) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+	token, ok := t.tokens[name]
+	return token, ok
+}
+
+func (t *savedTokens) set(name string, token string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.tokens[name] = token
 }
 
 type Account struct {
-	Password string `yaml:"password"`
-	Token    string `yaml:"token"`
+	Password     string                `yaml:"password"`
+	Token        string                `yaml:"token"`
+	Proxy        string                `yaml:"proxy,omitempty"`
+	Title        *string               `yaml:"title,omitempty"`
+	Notify       *bool                 `yaml:"notify,omitempty"`
+	IGTV         *IGTVOverride         `yaml:"igtv,omitempty"`
+	Announcement *AnnouncementOverride `yaml:"announcement,omitempty"`
+	proxyURL     *url.URL
+	// password is Password with secret providers resolved.
+	password string
+	// unresolved is set if Password refers to a secret that could not be
+	// resolved.
+	unresolved bool
+}
+
+// IGTVOverride replaces individual IGTV settings for a single account.
+// Fields that are not set fall back to the global IGTV settings.
+type IGTVOverride struct {
+	Enabled     *bool   `yaml:"enabled,omitempty"`
+	MinDuration *int    `yaml:"min_duration,omitempty"`
+	ShareToFeed *bool   `yaml:"share_to_feed,omitempty"`
+	Description *string `yaml:"description,omitempty"`
+}
+
+// AnnouncementOverride replaces individual announcement settings for a
+// single account. Fields that are not set fall back to the global settings.
+type AnnouncementOverride struct {
+	Message    *string `yaml:"message,omitempty"`
+	MinuteMark *int    `yaml:"minute_mark,omitempty"`
 }
 
+// StreamSettings are the effective stream-level settings of an account,
+// i.e. the global settings with the account overrides applied.
+type StreamSettings struct {
+	Title        string       `json:"title"`
+	Notify       bool         `json:"notify"`
+	IGTV         IGTV         `json:"igtv"`
+	Announcement Announcement `json:"announcement"`
+}
+
+type Destination struct {
+	URL string `yaml:"url"`
+}
+
+// LoadConfig reads, validates and applies the defaults to a config file.
+// If the file only has validation problems, the config is returned along
+// with a *ValidationError, for commands that do not need all of it.
 func LoadConfig(configPath string) (*Config, error) {
 	f, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return nil, err
 	}
 
+	// Unknown keys are reported along with the other problems, so that a
+	// typo does not silently fall back to a default.
+	var problems []string
 	var config Config
-	if err := yaml.Unmarshal(f, &config); err != nil {
+	if err := yaml.UnmarshalStrict(f, &config); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, fmt.Errorf("config: %v", err)
+		}
+		problems = append(problems, typeErr.Errors...)
+	}
+
+	overrides, err := applyEnv(&config, os.Environ())
+	if err != nil {
 		return nil, err
 	}
+	if len(overrides) > 0 {
+		log.Infof("config: applied environment overrides: %s", strings.Join(overrides, ", "))
+	}
+
+	if config.BindPort == 0 {
+		config.BindPort = defaultBindPort
+	}
 
 	if config.Encoder.Command == "" {
 		config.Encoder.Command = encoderCommand
@@ -90,6 +274,10 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.Encoder.Args = encoderArgs
 	}
 
+	if config.Encoder.OutputArgs == nil {
+		config.Encoder.OutputArgs = encoderOutputArgs
+	}
+
 	if config.Encoder.Height == 0 {
 		config.Encoder.Height = defaultHeight
 	}
@@ -106,6 +294,14 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.PollInterval = defaultPollInterval
 	}
 
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	if config.Recovery.Action == "" {
+		config.Recovery.Action = recoveryResume
+	}
+
 	if config.LogLevel == "" {
 		config.LogLevel = defaultLogLevel
 	}
@@ -114,27 +310,390 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.Logging.LogDirectory = "/var/log/broadcastd"
 	}
 
+	if config.Ingest.BindPort == 0 {
+		config.Ingest.BindPort = defaultIngestPort
+	}
+
+	if config.Ingest.App == "" {
+		config.Ingest.App = defaultIngestApp
+	}
+
+	if config.AutoLive.StartDelay == 0 {
+		config.AutoLive.StartDelay = defaultStartDelay
+	}
+
+	if config.AutoLive.StopDelay == 0 {
+		config.AutoLive.StopDelay = defaultStopDelay
+	}
+
+	if config.AutoLive.ProbeInterval == 0 {
+		config.AutoLive.ProbeInterval = defaultProbeInterval
+	}
+
+	if config.AutoLive.ProbeCommand == "" {
+		config.AutoLive.ProbeCommand = defaultProbeCommand
+	}
+
+	if config.Auth.SessionTTL == 0 {
+		config.Auth.SessionTTL = defaultSessionTTL
+	}
+
+	if config.Instagram.Retry.MaxAttempts == 0 {
+		config.Instagram.Retry.MaxAttempts = defaultMaxAttempts
+	}
+
+	if config.Instagram.Retry.InitialBackoff == 0 {
+		config.Instagram.Retry.InitialBackoff = defaultInitialBackoff
+	}
+
+	if config.Instagram.Retry.MaxBackoff == 0 {
+		config.Instagram.Retry.MaxBackoff = defaultMaxBackoff
+	}
+
+	if config.Instagram.Retry.Multiplier == 0 {
+		config.Instagram.Retry.Multiplier = defaultMultiplier
+	}
+
+	if config.Instagram.Retry.Jitter == 0 {
+		config.Instagram.Retry.Jitter = defaultJitter
+	}
+
+	for name, account := range config.Accounts {
+		if account == nil {
+			// Accounts may be listed by name only when their credentials
+			// are in the credential store.
+			account = &Account{}
+			config.Accounts[name] = account
+		}
+
+		password, err := resolveSecret(account.Password)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("accounts.%s.password: unable to resolve: %v", name, err))
+			account.unresolved = true
+		}
+		account.password = password
+
+		if account.Proxy == "" {
+			continue
+		}
+		proxyURL, err := instagram.ParseProxy(account.Proxy)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("accounts.%s.proxy: %v", name, err))
+		}
+		account.proxyURL = proxyURL
+	}
+
 	config.path = configPath
+	config.tokens = &savedTokens{tokens: make(map[string]string)}
+
+	if config.CredentialStore.Path != "" {
+		if err := config.openStore(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	problems = append(problems, config.validate()...)
+	if len(problems) > 0 {
+		return &config, &ValidationError{Path: configPath, Problems: problems}
+	}
 
 	return &config, nil
 }
 
-func (c *Config) SaveConfig() error {
-	b, err := yaml.Marshal(c)
+// openStore opens the credential store for reading. It is only written to
+// when a token is saved or credentials are migrated.
+func (c *Config) openStore() error {
+	key, err := loadStoreKey(c.CredentialStore.KeyFile)
 	if err != nil {
-		return err
+		return fmt.Errorf("credential_store: %v", err)
 	}
 
-	f, err := os.Create(c.path)
+	store, err := openCredentialStore(c.CredentialStore.Path, key)
 	if err != nil {
-		return err
+		return fmt.Errorf("credential_store: %v", err)
+	}
+	c.store = store
+	return nil
+}
+
+// MigrateCredentials copies the passwords and tokens that are still in the
+// config file into the credential store, if it is enabled. Loading a config
+// never changes the store, so this is done once when broadcastd starts.
+func (c *Config) MigrateCredentials() error {
+	store := c.store
+	if store == nil {
+		return nil
+	}
+
+	for name, account := range c.Accounts {
+		// Passwords from secret providers are never copied into the store.
+		password := account.password
+		if isSecretRef(account.Password) {
+			password = ""
+		}
+
+		if password == "" && account.Token == "" {
+			continue
+		}
+		log.Warnf("config: account %s has credentials in the config file, they are copied into the credential store but stay in the file until they are removed from it", name)
+
+		// A password in the config file replaces the stored one, since it
+		// can only have been changed there. A token in the file is older
+		// than the stored one, which broadcastd keeps up to date.
+		stored, _ := store.get(name)
+		merged := stored
+		if password != "" && password != merged.Password {
+			if merged.Password != "" {
+				log.Infof("config: password of account %s in the config file differs from the credential store, updating the store", name)
+			}
+			merged.Password = password
+		}
+		if merged.Token == "" {
+			merged.Token = account.Token
+		}
+		if merged == stored {
+			continue
+		}
+
+		err := store.update(name, func(credentials *Credentials) {
+			*credentials = merged
+		})
+		if err != nil {
+			return fmt.Errorf("credential_store: unable to save account %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Credentials returns the password and token of an account. A password in
+// the config file takes precedence over the credential store, and a token
+// in the store over the config file.
+func (c *Config) Credentials(name string) Credentials {
+	account := c.Accounts[name]
+	credentials := Credentials{
+		Password: account.password,
+		Token:    account.Token,
+	}
+	if token, ok := c.tokens.get(name); ok {
+		credentials.Token = token
+	}
+
+	if c.store == nil {
+		return credentials
 	}
+	if stored, ok := c.store.get(name); ok {
+		if credentials.Password == "" && !isSecretRef(account.Password) {
+			credentials.Password = stored.Password
+		}
+		if stored.Token != "" {
+			credentials.Token = stored.Token
+		}
+	}
+	return credentials
+}
+
+// SaveToken persists the session token of an account, in the credential
+// store if it is enabled, or else in the config file, to which an account
+// that is only set in the environment is added. An empty token removes it.
+// The token is used until the config is reloaded even if it cannot be
+// written to the file.
+func (c *Config) SaveToken(name string, token string) error {
+	if c.store != nil {
+		return c.store.update(name, func(credentials *Credentials) {
+			credentials.Token = token
+		})
+	}
+
+	c.tokens.set(name, token)
 
-	_, err = f.WriteString(string(b))
+	unlock := lockConfigFile(c.path)
+	defer unlock()
+	data, err := ioutil.ReadFile(c.path)
 	if err != nil {
-		f.Close()
 		return err
 	}
+	data, err = setAccountToken(data, name, token)
+	if err != nil {
+		return fmt.Errorf("config: unable to update %s: %v", c.path, err)
+	}
+	return writeFileAtomic(c.path, data, 0644)
+}
+
+// StreamSettings merges the overrides of the given account with the global
+// stream-level settings.
+func (c *Config) StreamSettings(name string) StreamSettings {
+	settings := StreamSettings{
+		Title:        c.Title,
+		Notify:       c.Notify,
+		IGTV:         c.IGTV,
+		Announcement: c.Announcement,
+	}
+
+	account, ok := c.Accounts[name]
+	if !ok || account == nil {
+		return settings
+	}
+
+	if account.Title != nil {
+		settings.Title = *account.Title
+	}
+
+	if account.Notify != nil {
+		settings.Notify = *account.Notify
+	}
+
+	if o := account.IGTV; o != nil {
+		if o.Enabled != nil {
+			settings.IGTV.Enabled = *o.Enabled
+		}
+		if o.MinDuration != nil {
+			settings.IGTV.MinDuration = *o.MinDuration
+		}
+		if o.ShareToFeed != nil {
+			settings.IGTV.ShareToFeed = *o.ShareToFeed
+		}
+		if o.Description != nil {
+			settings.IGTV.Description = *o.Description
+		}
+	}
+
+	if settings.IGTV.MinDuration < defaultIGTVMinDuration {
+		settings.IGTV.MinDuration = defaultIGTVMinDuration
+	}
+
+	if o := account.Announcement; o != nil {
+		if o.Message != nil {
+			settings.Announcement.Message = *o.Message
+		}
+		if o.MinuteMark != nil {
+			settings.Announcement.MinuteMark = *o.MinuteMark
+		}
+	}
+
+	return settings
+}
+
+func (c *Config) retryPolicy() instagram.RetryPolicy {
+	r := c.Instagram.Retry
+	return instagram.RetryPolicy{
+		MaxAttempts:    r.MaxAttempts,
+		InitialBackoff: time.Duration(r.InitialBackoff) * time.Millisecond,
+		MaxBackoff:     time.Duration(r.MaxBackoff) * time.Millisecond,
+		Multiplier:     r.Multiplier,
+		Jitter:         r.Jitter,
+	}
+}
+
+func (c *Config) rateLimits() instagram.RateLimits {
+	limits := instagram.RateLimits{
+		Default:   instagram.DefaultRateLimits.Default,
+		Endpoints: make(map[string]instagram.RateLimit),
+	}
+	for endpoint, limit := range instagram.DefaultRateLimits.Endpoints {
+		limits.Endpoints[endpoint] = limit
+	}
+
+	r := c.Instagram.RateLimits
+	if r.Default != nil {
+		limits.Default = instagram.RateLimit(*r.Default)
+	}
+	for endpoint, limit := range r.Endpoints {
+		limits.Endpoints[endpoint] = instagram.RateLimit(limit)
+	}
+	return limits
+}
+
+// InstagramOptions returns the options of the Instagram client of an
+// account.
+func (c *Config) InstagramOptions(name string) []instagram.Option {
+	opts := []instagram.Option{
+		instagram.WithRetryPolicy(c.retryPolicy()),
+		instagram.WithRateLimits(c.rateLimits()),
+	}
+	if c.Instagram.BaseURL != "" {
+		opts = append(opts, instagram.WithBaseURL(c.Instagram.BaseURL))
+	}
+	if account := c.Accounts[name]; account != nil && account.proxyURL != nil {
+		opts = append(opts, instagram.WithProxy(account.proxyURL))
+	}
+	return opts
+}
+
+const redacted = "REDACTED"
+
+// RedactedYAML returns the config as YAML with passwords, tokens, stream
+// keys and the user info of URLs replaced, so that the effective config can
+// be shown.
+func (c *Config) RedactedYAML() ([]byte, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var r Config
+	if err := yaml.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+
+	redact := func(s *string) {
+		if *s != "" {
+			*s = redacted
+		}
+	}
+
+	redact(&r.Ingest.StreamKey)
+	r.InputURL = redactURL(r.InputURL)
+
+	for _, account := range r.Accounts {
+		if account == nil {
+			continue
+		}
+		// References to secret providers are not secret themselves.
+		if !isSecretRef(account.Password) {
+			redact(&account.Password)
+		}
+		redact(&account.Token)
+		if u, err := url.Parse(account.Proxy); err == nil && u.User != nil {
+			account.Proxy = instagram.RedactProxy(u)
+		}
+	}
+
+	for _, destination := range r.Destinations {
+		if destination == nil {
+			continue
+		}
+		destination.URL = redactURL(destination.URL)
+	}
+
+	for username := range r.Auth.Users {
+		r.Auth.Users[username] = redacted
+	}
+	for i := range r.Auth.APITokens {
+		r.Auth.APITokens[i] = redacted
+	}
+	for i := range r.Auth.ReadOnlyTokens {
+		r.Auth.ReadOnlyTokens[i] = redacted
+	}
+
+	return yaml.Marshal(&r)
+}
 
-	return f.Close()
+// redactURL redacts the user info of an RTMP URL and the last segment of
+// its path, which is usually the stream key. A URL that cannot be parsed
+// is redacted as a whole.
+func redactURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	if u.User != nil {
+		u.User = url.User(redacted)
+	}
+	if i := strings.LastIndexByte(u.Path, '/'); i >= 0 && i < len(u.Path)-1 {
+		u.Path = u.Path[:i+1] + redacted
+		u.RawPath = ""
+	}
+	return u.String()
 }
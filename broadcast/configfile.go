@@ -0,0 +1,222 @@
+package broadcast
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// configFileLocks serialize the changes to each config file, which are
+// read, edited and written as a whole.
+var configFileLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+// lockConfigFile locks the config file at path and returns the function
+// that unlocks it.
+func lockConfigFile(path string) func() {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	configFileLocks.Lock()
+	lock, ok := configFileLocks.locks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		configFileLocks.locks[path] = lock
+	}
+	configFileLocks.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// setAccountToken sets the token of an account in the YAML document data.
+// Only the lines of the token are changed, so that the comments and
+// formatting of the rest of the document are kept as they are. An account
+// that is not in the document yet, e.g. one that is only set in the
+// environment, is added to it.
+func setAccountToken(data []byte, name string, token string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("document is not a mapping")
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	value := quoteScalar(token)
+
+	accountsKey, accounts := mappingValue(doc.Content[0], "accounts")
+	if accounts == nil || accounts.Tag == "!!null" {
+		if token == "" {
+			return data, nil
+		}
+		return []byte(strings.Join(addAccount(lines, doc.Content[0], accountsKey, accounts, name, value), "")), nil
+	}
+	if accounts.Kind != yaml.MappingNode || accounts.Style&yaml.FlowStyle != 0 {
+		return nil, fmt.Errorf("accounts is not a block mapping")
+	}
+	key, account := mappingValue(accounts, name)
+	if account == nil {
+		if token == "" {
+			return data, nil
+		}
+		first := accounts.Content[0]
+		indent := strings.Repeat(" ", first.Column-1)
+		nested := indent + strings.Repeat(" ", first.Column-accountsKey.Column)
+		lines = insertLine(lines, first.Line-1, indent+quoteScalar(name)+":\n")
+		lines = insertLine(lines, first.Line, nested+"token: "+value+"\n")
+		return []byte(strings.Join(lines, "")), nil
+	}
+
+	switch {
+	case account.Kind == yaml.ScalarNode && account.Tag == "!!null":
+		// The account is listed by name only, e.g. "name:" or "name: ~".
+		if account.Value != "" && account.Line == key.Line {
+			lines[key.Line-1] = strings.TrimRight(lines[key.Line-1][:account.Column-1], " ") + "\n"
+		}
+		indent := strings.Repeat(" ", key.Column-1+key.Column-accountsKey.Column)
+		line := indent + "token: " + value + "\n"
+		lines = insertLine(lines, key.Line, line)
+
+	case account.Kind == yaml.MappingNode && account.Style&yaml.FlowStyle == 0:
+		tokenKey, tokenValue := mappingValue(account, "token")
+		if tokenKey == nil {
+			first := account.Content[0]
+			line := strings.Repeat(" ", first.Column-1) + "token: " + value + "\n"
+			lines = insertLine(lines, first.Line-1, line)
+			break
+		}
+		if tokenValue.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("token of account %s is not a scalar", name)
+		}
+
+		line := strings.Repeat(" ", tokenKey.Column-1) + "token: " + value
+		if comment := tokenValue.LineComment; comment != "" && tokenValue.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			line += " " + comment
+		}
+		lines[tokenKey.Line-1] = line + "\n"
+		lines = removeContinuation(lines, tokenKey.Line, tokenKey.Column-1)
+
+	default:
+		return nil, fmt.Errorf("account %s is not a block mapping", name)
+	}
+
+	return []byte(strings.Join(lines, "")), nil
+}
+
+// addAccount adds an account with a token to a document whose accounts
+// key is empty or missing.
+func addAccount(lines []string, root *yaml.Node, accountsKey *yaml.Node, accounts *yaml.Node, name string, value string) []string {
+	column := root.Content[0].Column
+	n := len(lines)
+	if accountsKey != nil {
+		column = accountsKey.Column
+		n = accountsKey.Line
+		if accounts.Value != "" && accounts.Line == accountsKey.Line {
+			lines[n-1] = strings.TrimRight(lines[n-1][:accounts.Column-1], " ") + "\n"
+		}
+	}
+
+	indent := strings.Repeat(" ", column-1)
+	entry := []string{
+		indent + "  " + quoteScalar(name) + ":\n",
+		indent + "    token: " + value + "\n",
+	}
+	if accountsKey == nil {
+		entry = append([]string{indent + "accounts:\n"}, entry...)
+		if n > 0 && lines[n-1] == "" {
+			// The document ends with a newline.
+			n--
+		}
+	}
+	for i, line := range entry {
+		lines = insertLine(lines, n+i, line)
+	}
+	return lines
+}
+
+// mappingValue returns the key and value nodes of key in a mapping node.
+func mappingValue(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// removeContinuation removes the lines after the first n lines that
+// continue a value whose key is indented by indent, i.e. the lines of a
+// multi-line scalar.
+func removeContinuation(lines []string, n int, indent int) []string {
+	end := n
+	for i := n; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], "\r\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(line)-len(strings.TrimLeft(line, " ")) <= indent {
+			break
+		}
+		end = i + 1
+	}
+	return append(lines[:n], lines[end:]...)
+}
+
+// insertLine inserts line after the first n lines.
+func insertLine(lines []string, n int, line string) []string {
+	if n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n"
+	}
+	lines = append(lines, "")
+	copy(lines[n+1:], lines[n:])
+	lines[n] = line
+	return lines
+}
+
+func quoteScalar(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// writeFileAtomic replaces the file at path with data, so that it is never
+// left truncated. The target of a symlink is replaced rather than the
+// symlink itself.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
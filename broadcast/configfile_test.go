@@ -0,0 +1,311 @@
+package broadcast
+
+import (
+	"gopkg.in/yaml.v2"
+	"strings"
+	"testing"
+)
+
+func TestSetAccountToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		account string
+		token   string
+		input   string
+		want    string
+		err     string
+	}{
+		{
+			name:    "replaces the token and keeps comments",
+			account: "alice",
+			token:   "new",
+			input: `# Accounts to stream to.
+accounts:
+  # The main account.
+  alice:
+    password: 'secret' # not really
+    token: 'old' # saved by broadcastd
+  bob:
+    token: 'other'
+title: 'Live'
+`,
+			want: `# Accounts to stream to.
+accounts:
+  # The main account.
+  alice:
+    password: 'secret' # not really
+    token: 'new' # saved by broadcastd
+  bob:
+    token: 'other'
+title: 'Live'
+`,
+		},
+		{
+			name:    "adds a missing token key",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+    alice:
+        password: 'secret'
+title: 'Live'
+`,
+			want: `accounts:
+    alice:
+        token: 'new'
+        password: 'secret'
+title: 'Live'
+`,
+		},
+		{
+			name:    "account listed by name only",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+  alice:
+  bob: ~
+`,
+			want: `accounts:
+  alice:
+    token: 'new'
+  bob: ~
+`,
+		},
+		{
+			name:    "null account at the end without a newline",
+			account: "bob",
+			token:   "new",
+			input: `accounts:
+  alice:
+  bob: ~`,
+			want: `accounts:
+  alice:
+  bob:
+    token: 'new'
+`,
+		},
+		{
+			name:    "quoted account name",
+			account: "alice.live",
+			token:   "new",
+			input: `accounts:
+  'alice.live':
+    token: "old"
+  "bob.live":
+    token: "old"
+`,
+			want: `accounts:
+  'alice.live':
+    token: 'new'
+  "bob.live":
+    token: "old"
+`,
+		},
+		{
+			name:    "multi-line plain token",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+  alice:
+    token: abcdef
+      ghijkl
+      mnopqr
+    password: 'secret'
+`,
+			want: `accounts:
+  alice:
+    token: 'new'
+    password: 'secret'
+`,
+		},
+		{
+			name:    "block scalar token",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+  alice:
+    token: |
+      abcdef
+
+      ghijkl
+  bob:
+    token: 'other'
+`,
+			want: `accounts:
+  alice:
+    token: 'new'
+  bob:
+    token: 'other'
+`,
+		},
+		{
+			name:    "empty token",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+  alice:
+    token:
+    password: 'secret'
+`,
+			want: `accounts:
+  alice:
+    token: 'new'
+    password: 'secret'
+`,
+		},
+		{
+			name:    "removes the token",
+			account: "alice",
+			token:   "",
+			input: `accounts:
+  alice:
+    token: 'old'
+`,
+			want: `accounts:
+  alice:
+    token: ''
+`,
+		},
+		{
+			name:    "quotes the token",
+			account: "alice",
+			token:   "it's: #1",
+			input: `accounts:
+  alice:
+    token: 'old'
+`,
+			want: `accounts:
+  alice:
+    token: 'it''s: #1'
+`,
+		},
+		{
+			name:    "adds a missing account",
+			account: "carol.tv",
+			token:   "new",
+			input: `accounts:
+  alice:
+    token: 'old'
+`,
+			want: `accounts:
+  'carol.tv':
+    token: 'new'
+  alice:
+    token: 'old'
+`,
+		},
+		{
+			name:    "removing the token of a missing account",
+			account: "carol",
+			token:   "",
+			input: `accounts:
+  alice:
+    token: 'old'
+`,
+			want: `accounts:
+  alice:
+    token: 'old'
+`,
+		},
+		{
+			name:    "empty accounts",
+			account: "alice",
+			token:   "new",
+			input: `accounts: ~ # set in the environment
+title: 'Live'
+`,
+			want: `accounts:
+  'alice':
+    token: 'new'
+title: 'Live'
+`,
+		},
+		{
+			name:    "flow-style account",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+  alice: {password: 'secret'}
+`,
+			err: "account alice is not a block mapping",
+		},
+		{
+			name:    "flow-style accounts",
+			account: "alice",
+			token:   "new",
+			input: `accounts: {alice: {password: 'secret'}}
+`,
+			err: "accounts is not a block mapping",
+		},
+		{
+			name:    "token is not a scalar",
+			account: "alice",
+			token:   "new",
+			input: `accounts:
+  alice:
+    token:
+      - 'old'
+`,
+			err: "token of account alice is not a scalar",
+		},
+		{
+			name:    "no accounts",
+			account: "alice",
+			token:   "new",
+			input: `title: 'Live'
+`,
+			want: `title: 'Live'
+accounts:
+  'alice':
+    token: 'new'
+`,
+		},
+		{
+			name:    "no accounts without a newline",
+			account: "alice",
+			token:   "new",
+			input:   `title: 'Live'`,
+			want: `title: 'Live'
+accounts:
+  'alice':
+    token: 'new'
+`,
+		},
+		{
+			name:    "not a mapping",
+			account: "alice",
+			token:   "new",
+			input: `- alice
+`,
+			err: "document is not a mapping",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setAccountToken([]byte(tt.input), tt.account, tt.token)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+
+			var config Config
+			if err := yaml.Unmarshal(got, &config); err != nil {
+				t.Fatalf("result does not parse: %v", err)
+			}
+			if tt.token == "" && config.Accounts[tt.account] == nil {
+				return
+			}
+			if account := config.Accounts[tt.account]; account == nil || account.Token != tt.token {
+				t.Fatalf("token of %s is not %q after parsing the result", tt.account, tt.token)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package broadcast
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	envPrefix = "BROADCASTD"
+	// envKeyField is the variable name suffix that gives the exact key of
+	// a map entry.
+	envKeyField = "KEY"
+)
+
+// applyEnv overrides config fields with BROADCASTD_* environment
+// variables, which take precedence over the config file. The name of a
+// variable is the upper-cased path of its YAML key, e.g.
+// BROADCASTD_IGTV_ENABLED for igtv.enabled. Map entries are addressed by
+// their key, e.g. BROADCASTD_ACCOUNTS_ALICE_PASSWORD, which adds the entry
+// if it does not exist yet (see applyEnvMap). Values other than strings
+// are parsed as YAML, e.g. [a, b] for lists. It returns the names of the
+// variables applied.
+func applyEnv(config *Config, environ []string) ([]string, error) {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv, envPrefix+"_") {
+			continue
+		}
+		env[kv[:i]] = kv[i+1:]
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+
+	var applied []string
+	err := applyEnvStruct(reflect.ValueOf(config).Elem(), envPrefix, env, &applied)
+	sort.Strings(applied)
+	return applied, err
+}
+
+func applyEnvStruct(v reflect.Value, prefix string, env map[string]string, applied *[]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			continue
+		}
+		if err := applyEnvValue(v.Field(i), prefix+"_"+envName(key), env, applied); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyEnvValue(v reflect.Value, name string, env map[string]string, applied *[]string) error {
+	if value, ok := env[name]; ok {
+		if err := setEnvValue(v, value); err != nil {
+			return fmt.Errorf("config: %s: %v", name, err)
+		}
+		*applied = append(*applied, name)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return applyEnvStruct(v, name, env, applied)
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		if v.IsNil() {
+			// Only allocate the struct if one of its fields is overridden.
+			elem := reflect.New(v.Type().Elem())
+			var set []string
+			if err := applyEnvStruct(elem.Elem(), name, env, &set); err != nil {
+				return err
+			}
+			if len(set) > 0 {
+				v.Set(elem)
+				*applied = append(*applied, set...)
+			}
+			return nil
+		}
+		return applyEnvStruct(v.Elem(), name, env, applied)
+	case reflect.Map:
+		return applyEnvMap(v, name, env, applied)
+	}
+	return nil
+}
+
+// applyEnvMap applies the variables of every map entry. Entries are
+// matched by key, so keys that only differ in case or punctuation cannot
+// be told apart. An entry that only exists in the environment gets the
+// lower-cased name as its key, unless the exact key is given by a _KEY
+// variable, e.g. BROADCASTD_ACCOUNTS_ALICE_TV_KEY=alice.tv.
+func applyEnvMap(v reflect.Value, prefix string, env map[string]string, applied *[]string) error {
+	keys := make(map[string]string)
+	for _, key := range v.MapKeys() {
+		keys[envName(key.String())] = key.String()
+	}
+
+	// Find entries that only exist in the environment.
+	elemType := v.Type().Elem()
+	for name := range env {
+		if !strings.HasPrefix(name, prefix+"_") {
+			continue
+		}
+		rest := name[len(prefix)+1:]
+		entry := rest
+		if isStructType(elemType) {
+			i := strings.LastIndex(rest, "_")
+			for _, field := range append(envFieldNames(elemType), envKeyField) {
+				if strings.HasSuffix(rest, "_"+field) {
+					i = len(rest) - len(field) - 1
+					break
+				}
+			}
+			if i <= 0 {
+				continue
+			}
+			entry = rest[:i]
+		}
+		if _, ok := keys[entry]; !ok {
+			keys[entry] = strings.ToLower(entry)
+		}
+	}
+
+	keyVars := make(map[string]string)
+	if isStructType(elemType) {
+		for entry, key := range keys {
+			name := prefix + "_" + entry + "_" + envKeyField
+			value, ok := env[name]
+			if !ok {
+				continue
+			}
+			if envName(value) != entry {
+				return fmt.Errorf("config: %s: %q does not match the name of the variable", name, value)
+			}
+			if v.MapIndex(reflect.ValueOf(key)).IsValid() && key != value {
+				return fmt.Errorf("config: %s: the entry already exists as %q", name, key)
+			}
+			keys[entry] = value
+			keyVars[entry] = name
+		}
+	}
+
+	if v.IsNil() && len(keys) > 0 {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+
+	for entry, key := range keys {
+		mapKey := reflect.ValueOf(key)
+		elem := reflect.New(elemType).Elem()
+		if existing := v.MapIndex(mapKey); existing.IsValid() {
+			elem.Set(existing)
+		}
+
+		var set []string
+		if err := applyEnvValue(elem, prefix+"_"+entry, env, &set); err != nil {
+			return err
+		}
+		if len(set) > 0 {
+			v.SetMapIndex(mapKey, elem)
+			*applied = append(*applied, set...)
+			if name, ok := keyVars[entry]; ok {
+				*applied = append(*applied, name)
+			}
+		}
+	}
+	return nil
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.String {
+		v.SetString(value)
+		return nil
+	}
+	if v.Kind() == reflect.Ptr && v.Type().Elem().Kind() == reflect.String {
+		v.Set(reflect.ValueOf(&value))
+		return nil
+	}
+
+	ptr := reflect.New(v.Type())
+	if err := yaml.UnmarshalStrict([]byte(value), ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+func isStructType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+// envFieldNames returns the variable name suffixes of every field of a
+// struct type, longest first so that nested fields match before their
+// parents.
+func envFieldNames(t reflect.Type) []string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" {
+			continue
+		}
+		name := envName(key)
+		names = append(names, name)
+		if isStructType(t.Field(i).Type) {
+			for _, nested := range envFieldNames(t.Field(i).Type) {
+				names = append(names, name+"_"+nested)
+			}
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	return names
+}
+
+// envName converts a YAML key to its environment variable form.
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
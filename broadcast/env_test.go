@@ -0,0 +1,224 @@
+package broadcast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		check   func(t *testing.T, c *Config)
+		applied []string
+		err     string
+	}{
+		{
+			name:    "nested field",
+			environ: []string{"BROADCASTD_IGTV_ENABLED=true"},
+			check: func(t *testing.T, c *Config) {
+				if !c.IGTV.Enabled {
+					t.Error("igtv.enabled is not set")
+				}
+			},
+			applied: []string{"BROADCASTD_IGTV_ENABLED"},
+		},
+		{
+			name:    "integers",
+			environ: []string{"BROADCASTD_BIND_PORT=8080", "BROADCASTD_IGTV_MIN_DURATION=30"},
+			check: func(t *testing.T, c *Config) {
+				if c.BindPort != 8080 || c.IGTV.MinDuration != 30 {
+					t.Errorf("bind_port = %d, igtv.min_duration = %d, want 8080 and 30", c.BindPort, c.IGTV.MinDuration)
+				}
+			},
+			applied: []string{"BROADCASTD_BIND_PORT", "BROADCASTD_IGTV_MIN_DURATION"},
+		},
+		{
+			name:    "list",
+			environ: []string{"BROADCASTD_AUTH_API_TOKENS=[a, b]"},
+			check: func(t *testing.T, c *Config) {
+				if !reflect.DeepEqual(c.Auth.APITokens, []string{"a", "b"}) {
+					t.Errorf("auth.api_tokens = %q, want [a b]", c.Auth.APITokens)
+				}
+			},
+			applied: []string{"BROADCASTD_AUTH_API_TOKENS"},
+		},
+		{
+			name:    "strings are not parsed",
+			environ: []string{"BROADCASTD_TITLE=[live]: #1"},
+			check: func(t *testing.T, c *Config) {
+				if c.Title != "[live]: #1" {
+					t.Errorf("title = %q, want the value as is", c.Title)
+				}
+			},
+			applied: []string{"BROADCASTD_TITLE"},
+		},
+		{
+			name:    "pointer fields",
+			environ: []string{"BROADCASTD_ACCOUNTS_ALICE_TITLE=Hello", "BROADCASTD_ACCOUNTS_ALICE_IGTV_ENABLED=false"},
+			check: func(t *testing.T, c *Config) {
+				account := c.Accounts["alice"]
+				if account.Title == nil || *account.Title != "Hello" {
+					t.Errorf("title = %v, want Hello", account.Title)
+				}
+				if account.IGTV == nil || account.IGTV.Enabled == nil || *account.IGTV.Enabled {
+					t.Errorf("igtv = %+v, want enabled to be false", account.IGTV)
+				}
+				if account.Notify != nil || account.Announcement != nil {
+					t.Error("fields that are not in the environment are set")
+				}
+			},
+			applied: []string{"BROADCASTD_ACCOUNTS_ALICE_IGTV_ENABLED", "BROADCASTD_ACCOUNTS_ALICE_TITLE"},
+		},
+		{
+			name:    "map of strings",
+			environ: []string{"BROADCASTD_AUTH_USERS_ADMIN=hash"},
+			check: func(t *testing.T, c *Config) {
+				if c.Auth.Users["admin"] != "hash" {
+					t.Errorf("auth.users = %v, want admin", c.Auth.Users)
+				}
+			},
+			applied: []string{"BROADCASTD_AUTH_USERS_ADMIN"},
+		},
+		{
+			name:    "other variables are ignored",
+			environ: []string{"BROADCASTD_UNKNOWN=1", "HOME=/root", "BROADCASTDX_BIND_PORT=1"},
+			check: func(t *testing.T, c *Config) {
+				if c.BindPort != 0 {
+					t.Errorf("bind_port = %d, want it unchanged", c.BindPort)
+				}
+			},
+		},
+		{
+			name:    "not an integer",
+			environ: []string{"BROADCASTD_BIND_PORT=http"},
+			err:     "config: BROADCASTD_BIND_PORT:",
+		},
+		{
+			name:    "not a boolean",
+			environ: []string{"BROADCASTD_IGTV_ENABLED=maybe"},
+			err:     "config: BROADCASTD_IGTV_ENABLED:",
+		},
+		{
+			name:    "not a list",
+			environ: []string{"BROADCASTD_AUTH_API_TOKENS={a: b}"},
+			err:     "config: BROADCASTD_AUTH_API_TOKENS:",
+		},
+		{
+			name:    "bad value of a pointer field",
+			environ: []string{"BROADCASTD_ACCOUNTS_ALICE_IGTV_MIN_DURATION=soon"},
+			err:     "config: BROADCASTD_ACCOUNTS_ALICE_IGTV_MIN_DURATION:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			applied, err := applyEnv(&config, tt.environ)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(applied, ",") != strings.Join(tt.applied, ",") {
+				t.Errorf("applied = %q, want %q", applied, tt.applied)
+			}
+			tt.check(t, &config)
+		})
+	}
+}
+
+func TestApplyEnvAccountKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts map[string]*Account
+		environ  []string
+		want     map[string]string
+		err      string
+	}{
+		{
+			name:    "environment account is lower-cased",
+			environ: []string{"BROADCASTD_ACCOUNTS_ALICE_PASSWORD=secret"},
+			want:    map[string]string{"alice": "secret"},
+		},
+		{
+			name: "key gives the exact name",
+			environ: []string{
+				"BROADCASTD_ACCOUNTS_ALICE_TV_PASSWORD=secret",
+				"BROADCASTD_ACCOUNTS_ALICE_TV_KEY=Alice.TV",
+			},
+			want: map[string]string{"Alice.TV": "secret"},
+		},
+		{
+			name:     "account from the file is matched by name",
+			accounts: map[string]*Account{"alice.tv": {Password: "old"}},
+			environ:  []string{"BROADCASTD_ACCOUNTS_ALICE_TV_PASSWORD=secret"},
+			want:     map[string]string{"alice.tv": "secret"},
+		},
+		{
+			name:     "key of an account from the file",
+			accounts: map[string]*Account{"alice.tv": {Password: "old"}},
+			environ: []string{
+				"BROADCASTD_ACCOUNTS_ALICE_TV_PASSWORD=secret",
+				"BROADCASTD_ACCOUNTS_ALICE_TV_KEY=alice.tv",
+			},
+			want: map[string]string{"alice.tv": "secret"},
+		},
+		{
+			name:     "key differs from the account in the file",
+			accounts: map[string]*Account{"alice.tv": {Password: "old"}},
+			environ: []string{
+				"BROADCASTD_ACCOUNTS_ALICE_TV_PASSWORD=secret",
+				"BROADCASTD_ACCOUNTS_ALICE_TV_KEY=alice_tv",
+			},
+			err: `the entry already exists as "alice.tv"`,
+		},
+		{
+			name: "key does not match the variable",
+			environ: []string{
+				"BROADCASTD_ACCOUNTS_ALICE_PASSWORD=secret",
+				"BROADCASTD_ACCOUNTS_ALICE_KEY=bob",
+			},
+			err: `"bob" does not match the name of the variable`,
+		},
+		{
+			name:    "key alone does not add an account",
+			environ: []string{"BROADCASTD_ACCOUNTS_ALICE_KEY=alice"},
+			want:    map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Accounts: tt.accounts}
+			_, err := applyEnv(&config, tt.environ)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string]string)
+			for name, account := range config.Accounts {
+				got[name] = account.Password
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got accounts %v, want %v", got, tt.want)
+			}
+			for name, password := range tt.want {
+				if got[name] != password {
+					t.Errorf("password of %s = %q, want %q", name, got[name], password)
+				}
+			}
+		})
+	}
+}
@@ -3,10 +3,12 @@ package broadcast
 import (
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/sbekti/broadcastd/rtmp"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/net/websocket"
 	"net/http"
-	"sort"
+	"time"
 )
 
 type postLiveReq struct {
@@ -18,19 +20,53 @@ type postLiveRes struct {
 	Error  string `json:"error"`
 }
 
+type apiRes struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
 type indexRes struct {
+	Auth    bool
 	Input   statusInfo
-	Outputs []outputInfo
+	Ingest  *rtmp.State
+	Viewers ViewerStats
+	Outputs []StreamInfo
 }
 
 type statusInfo struct {
-	Live bool
+	Live     bool
+	AutoLive bool
+}
+
+type loginRes struct {
+	Next  string
+	Error string
+}
+
+type getStatusRes struct {
+	Live     bool         `json:"live"`
+	AutoLive bool         `json:"auto_live"`
+	Ingest   *rtmp.State  `json:"ingest,omitempty"`
+	Streams  []StreamInfo `json:"streams"`
+}
+
+type getStreamsRes struct {
+	Streams []StreamInfo `json:"streams"`
+}
+
+type getViewersRes struct {
+	ViewerStats
+	Streams map[string]ViewerStats `json:"streams"`
+}
+
+type postConfigReloadRes struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	*ReloadReport
 }
 
-type outputInfo struct {
-	Name              string
-	Status            string
-	ChallengeRequired bool
+type postStreamSecurityCodeReq struct {
+	SecurityCode string `json:"security_code"`
 }
 
 type getSecurityCodeRes struct {
@@ -46,29 +82,84 @@ type postSecurityCodeRes struct {
 func GetIndex(c echo.Context) error {
 	sc := c.(*StateContext)
 
-	var keys []string
-	for k := range sc.streams {
-		keys = append(keys, k)
+	data := &indexRes{
+		Auth: sc.Config().Auth.Enabled,
+		Input: statusInfo{
+			Live:     sc.isStreaming(),
+			AutoLive: sc.Config().AutoLive.Enabled,
+		},
+		Ingest:  sc.IngestState(),
+		Viewers: sc.viewers.stats(false),
+		Outputs: sc.StreamInfos(),
 	}
-	sort.Strings(keys)
 
-	var outputs []outputInfo
-	for _, key := range keys {
-		outputs = append(outputs, outputInfo{
-			Name:              sc.streams[key].name,
-			Status:            sc.streams[key].status,
-			ChallengeRequired: sc.streams[key].status == challengeRequired,
+	return c.Render(http.StatusOK, "index", data)
+}
+
+func GetLogin(c echo.Context) error {
+	data := &loginRes{
+		Next: safeRedirect(c.QueryParam("next")),
+	}
+
+	return c.Render(http.StatusOK, "login", data)
+}
+
+func PostLogin(c echo.Context) error {
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+	next := safeRedirect(c.FormValue("next"))
+
+	sc := c.(*StateContext)
+
+	if !sc.Config().Auth.Enabled {
+		return c.Redirect(http.StatusSeeOther, next)
+	}
+
+	hash, ok := sc.Config().Auth.Users[username]
+	if !ok || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		log.Warnf("auth: failed login attempt for %s from %s", username, c.RealIP())
+		return c.Render(http.StatusUnauthorized, "login", &loginRes{
+			Next:  next,
+			Error: "Invalid username or password.",
 		})
 	}
 
-	data := &indexRes{
-		Input: statusInfo{
-			Live: sc.streaming,
-		},
-		Outputs: outputs,
+	token, err := sc.sessions.create(username)
+	if err != nil {
+		return err
 	}
 
-	return c.Render(http.StatusOK, "index", data)
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   sc.Config().Auth.SessionTTL * 3600,
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Infof("auth: %s logged in from %s", username, c.RealIP())
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+func PostLogout(c echo.Context) error {
+	sc := c.(*StateContext)
+
+	if cookie, err := c.Cookie(sessionCookieName); err == nil {
+		sc.sessions.delete(cookie.Value)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.Redirect(http.StatusSeeOther, "/login")
 }
 
 func GetSecurityCode(c echo.Context) error {
@@ -87,14 +178,15 @@ func PostSecurityCode(c echo.Context) error {
 
 	sc := c.(*StateContext)
 
-	if _, ok := sc.streams[account]; !ok {
+	stream, ok := sc.stream(account)
+	if !ok {
 		return c.JSON(http.StatusBadRequest, postSecurityCodeRes{
 			Status: "error",
 			Error:  fmt.Sprintf("account %s does not exist", account),
 		})
 	}
 
-	err := sc.streams[account].PutSecurityCode(securityCode)
+	err := stream.PutSecurityCode(securityCode)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, postSecurityCodeRes{
 			Status: "error",
@@ -141,6 +233,54 @@ func WebSocketComments(c echo.Context) error {
 	return nil
 }
 
+func WebSocketStatus(c echo.Context) error {
+	sc := c.(*StateContext)
+
+	websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		events := sc.subscribeStatus()
+		defer sc.unsubscribeStatus(events)
+
+		for _, info := range sc.StreamInfos() {
+			e := StatusEvent{
+				Event:  statusEventSnapshot,
+				Time:   time.Now(),
+				Stream: info,
+			}
+			if err := websocket.JSON.Send(ws, e); err != nil {
+				log.Errorf("ws: send: %v", err)
+				return
+			}
+		}
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			msg := ""
+			for {
+				if err := websocket.Message.Receive(ws, &msg); err != nil {
+					return
+				}
+				log.Debugf("ws: received: %s", msg)
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case e := <-events:
+				if err := websocket.JSON.Send(ws, e); err != nil {
+					log.Errorf("ws: send: %v", err)
+					return
+				}
+			}
+		}
+	}).ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
 func PostLive(c echo.Context) error {
 	req := new(postLiveReq)
 	if err := c.Bind(req); err != nil {
@@ -168,3 +308,166 @@ func PostLive(c echo.Context) error {
 		})
 	}
 }
+
+func PostStreamLive(c echo.Context) error {
+	account := c.Param("account")
+
+	req := new(postLiveReq)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	sc := c.(*StateContext)
+
+	if _, ok := sc.stream(account); !ok {
+		return c.JSON(http.StatusNotFound, postLiveRes{
+			Status: "error",
+			Error:  fmt.Sprintf("account %s does not exist", account),
+		})
+	}
+
+	var err error
+
+	if req.Live {
+		err = sc.Broadcast.StartStream(account)
+	} else {
+		err = sc.Broadcast.StopStream(account)
+	}
+
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, postLiveRes{
+			Status: "error",
+			Error:  err.Error(),
+		})
+	} else {
+		return c.JSON(http.StatusOK, postLiveRes{
+			Status: "ok",
+			Error:  "",
+		})
+	}
+}
+
+func GetStatus(c echo.Context) error {
+	sc := c.(*StateContext)
+
+	return c.JSON(http.StatusOK, getStatusRes{
+		Live:     sc.isStreaming(),
+		AutoLive: sc.Config().AutoLive.Enabled,
+		Ingest:   sc.IngestState(),
+		Streams:  sc.StreamInfos(),
+	})
+}
+
+func GetStreams(c echo.Context) error {
+	sc := c.(*StateContext)
+
+	return c.JSON(http.StatusOK, getStreamsRes{
+		Streams: sc.StreamInfos(),
+	})
+}
+
+func GetStream(c echo.Context) error {
+	account := c.Param("account")
+
+	sc := c.(*StateContext)
+
+	stream, ok := sc.stream(account)
+	if !ok {
+		return c.JSON(http.StatusNotFound, apiRes{
+			Status: "error",
+			Error:  fmt.Sprintf("account %s does not exist", account),
+		})
+	}
+
+	return c.JSON(http.StatusOK, stream.Info())
+}
+
+func GetViewers(c echo.Context) error {
+	sc := c.(*StateContext)
+
+	combined, streams := sc.ViewerStats()
+
+	return c.JSON(http.StatusOK, getViewersRes{
+		ViewerStats: combined,
+		Streams:     streams,
+	})
+}
+
+func GetStreamViewers(c echo.Context) error {
+	account := c.Param("account")
+
+	sc := c.(*StateContext)
+
+	stream, ok := sc.stream(account)
+	if !ok {
+		return c.JSON(http.StatusNotFound, apiRes{
+			Status: "error",
+			Error:  fmt.Sprintf("account %s does not exist", account),
+		})
+	}
+
+	return c.JSON(http.StatusOK, stream.viewers.stats(true))
+}
+
+func PostStreamSecurityCode(c echo.Context) error {
+	account := c.Param("account")
+
+	req := new(postStreamSecurityCodeReq)
+	if err := c.Bind(req); err != nil {
+		return err
+	}
+
+	sc := c.(*StateContext)
+
+	stream, ok := sc.stream(account)
+	if !ok {
+		return c.JSON(http.StatusNotFound, apiRes{
+			Status: "error",
+			Error:  fmt.Sprintf("account %s does not exist", account),
+		})
+	}
+
+	if req.SecurityCode == "" {
+		return c.JSON(http.StatusBadRequest, apiRes{
+			Status: "error",
+			Error:  "security_code is required",
+		})
+	}
+
+	if stream.Info().Status != challengeRequired {
+		return c.JSON(http.StatusConflict, apiRes{
+			Status: "error",
+			Error:  fmt.Sprintf("account %s is not waiting for a security code", account),
+		})
+	}
+
+	if err := stream.PutSecurityCode(req.SecurityCode); err != nil {
+		return c.JSON(http.StatusBadRequest, apiRes{
+			Status: "error",
+			Error:  err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, apiRes{
+		Status: "ok",
+		Error:  "",
+	})
+}
+
+func PostConfigReload(c echo.Context) error {
+	sc := c.(*StateContext)
+
+	report, err := sc.Reload()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, postConfigReloadRes{
+			Status: "error",
+			Error:  err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, postConfigReloadRes{
+		Status:       "ok",
+		Error:        "",
+		ReloadReport: report,
+	})
+}
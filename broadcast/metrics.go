@@ -0,0 +1,241 @@
+package broadcast
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sbekti/broadcastd/instagram"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	metricsNamespace = "broadcastd"
+)
+
+var allStates = []State{
+	ready,
+	loggingIn,
+	loginError,
+	challengeRequired,
+	challengeError,
+	creatingBroadcast,
+	createBroadcastError,
+	streaming,
+	encoderRestart,
+	posting,
+}
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	encoderRestarts          *prometheus.CounterVec
+	loginAttempts            *prometheus.CounterVec
+	challenges               *prometheus.CounterVec
+	commentsReceived         *prometheus.CounterVec
+	instagramRequests        *prometheus.CounterVec
+	instagramRequestDuration *prometheus.HistogramVec
+	rateLimitWait            *prometheus.CounterVec
+	rateLimitedRequests      *prometheus.CounterVec
+	httpErrors               *prometheus.CounterVec
+}
+
+func newMetrics(b *Broadcast) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		encoderRestarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "encoder_restarts_total",
+			Help:      "Number of times the encoder of a stream was restarted.",
+		}, []string{"account"}),
+		loginAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "login_attempts_total",
+			Help:      "Number of Instagram login attempts by method and result.",
+		}, []string{"account", "method", "result"}),
+		challenges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "challenges_total",
+			Help:      "Number of login challenges received.",
+		}, []string{"account"}),
+		commentsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "comments_received_total",
+			Help:      "Number of live comments received.",
+		}, []string{"account"}),
+		instagramRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "instagram_requests_total",
+			Help:      "Number of Instagram API requests by endpoint and HTTP status.",
+		}, []string{"account", "endpoint", "status"}),
+		instagramRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "instagram_request_duration_seconds",
+			Help:      "Latency of Instagram API requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+		rateLimitWait: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "instagram_rate_limit_wait_seconds_total",
+			Help:      "Time Instagram API requests spent waiting for the client-side rate limiter.",
+		}, []string{"account", "endpoint"}),
+		rateLimitedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "instagram_rate_limited_requests_total",
+			Help:      "Number of Instagram API requests delayed by the client-side rate limiter.",
+		}, []string{"account", "endpoint"}),
+		httpErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_errors_total",
+			Help:      "Number of HTTP requests to broadcastd that failed, by status code.",
+		}, []string{"code"}),
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		m.encoderRestarts,
+		m.loginAttempts,
+		m.challenges,
+		m.commentsReceived,
+		m.instagramRequests,
+		m.instagramRequestDuration,
+		m.rateLimitWait,
+		m.rateLimitedRequests,
+		m.httpErrors,
+		&streamCollector{b: b},
+	)
+
+	return m
+}
+
+func (m *metrics) handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// observeRequest returns an Instagram request observer for the given
+// account.
+func (m *metrics) observeRequest(account string) instagram.RequestObserver {
+	return func(r instagram.RequestInfo) {
+		status := "error"
+		if r.StatusCode != 0 {
+			status = strconv.Itoa(r.StatusCode)
+		}
+		m.instagramRequests.WithLabelValues(account, r.Endpoint, status).Inc()
+		m.instagramRequestDuration.WithLabelValues(r.Endpoint).Observe(r.Duration.Seconds())
+		if r.Wait > 0 {
+			m.rateLimitWait.WithLabelValues(account, r.Endpoint).Add(r.Wait.Seconds())
+			m.rateLimitedRequests.WithLabelValues(account, r.Endpoint).Inc()
+		}
+	}
+}
+
+// metricsMiddleware counts the HTTP requests that end with an error status.
+func metricsMiddleware(m *metrics) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			code := c.Response().Status
+			if err != nil {
+				code = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					code = he.Code
+				}
+			}
+			if code >= 400 {
+				m.httpErrors.WithLabelValues(strconv.Itoa(code)).Inc()
+			}
+
+			return err
+		}
+	}
+}
+
+var (
+	streamUpDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_up",
+		"Whether the stream is started and currently streaming.",
+		[]string{"account", "type"}, nil)
+	streamLiveDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_live",
+		"Whether the stream is started.",
+		[]string{"account", "type"}, nil)
+	streamStateDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_state",
+		"The current state of the stream, 1 for the current state and 0 otherwise.",
+		[]string{"account", "state"}, nil)
+	streamRetriesDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_retries",
+		"Number of consecutive failures of the stream.",
+		[]string{"account"}, nil)
+	streamViewersDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_viewers",
+		"Current number of viewers of the broadcast.",
+		[]string{"account"}, nil)
+	streamUniqueViewersDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_unique_viewers",
+		"Total number of unique viewers of the broadcast.",
+		[]string{"account"}, nil)
+	streamUptimeDesc = prometheus.NewDesc(
+		metricsNamespace+"_stream_uptime_seconds",
+		"Time since the broadcast started.",
+		[]string{"account"}, nil)
+	viewersDesc = prometheus.NewDesc(
+		metricsNamespace+"_viewers",
+		"Current number of viewers across all accounts.",
+		nil, nil)
+)
+
+// streamCollector reports the state of every stream at scrape time.
+type streamCollector struct {
+	b *Broadcast
+}
+
+func (c *streamCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- streamUpDesc
+	ch <- streamLiveDesc
+	ch <- streamStateDesc
+	ch <- streamRetriesDesc
+	ch <- streamViewersDesc
+	ch <- streamUniqueViewersDesc
+	ch <- streamUptimeDesc
+	ch <- viewersDesc
+}
+
+func (c *streamCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, info := range c.b.StreamInfos() {
+		up := info.Live && info.Status == streaming
+		ch <- prometheus.MustNewConstMetric(streamUpDesc, prometheus.GaugeValue, boolToFloat(up), info.Name, info.Type)
+		ch <- prometheus.MustNewConstMetric(streamLiveDesc, prometheus.GaugeValue, boolToFloat(info.Live), info.Name, info.Type)
+
+		for _, state := range allStates {
+			ch <- prometheus.MustNewConstMetric(streamStateDesc, prometheus.GaugeValue,
+				boolToFloat(info.Status == state), info.Name, string(state))
+		}
+
+		ch <- prometheus.MustNewConstMetric(streamRetriesDesc, prometheus.GaugeValue, float64(info.State.Retries), info.Name)
+
+		if info.Type != instagramStream {
+			continue
+		}
+
+		uptime := 0.0
+		if info.StartTime != nil {
+			uptime = time.Since(*info.StartTime).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(streamViewersDesc, prometheus.GaugeValue, float64(info.ViewerCount), info.Name)
+		ch <- prometheus.MustNewConstMetric(streamUniqueViewersDesc, prometheus.GaugeValue, float64(info.TotalUniqueViewerCount), info.Name)
+		ch <- prometheus.MustNewConstMetric(streamUptimeDesc, prometheus.GaugeValue, uptime, info.Name)
+	}
+
+	ch <- prometheus.MustNewConstMetric(viewersDesc, prometheus.GaugeValue, float64(c.b.viewers.stats(false).ViewerCount))
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
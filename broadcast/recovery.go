@@ -0,0 +1,258 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	recoveryResume = "resume"
+	recoveryEnd    = "end"
+)
+
+// activeBroadcast is what is kept on disk about a started stream, so that
+// its broadcast can be resumed or ended if broadcastd restarts without
+// stopping it.
+type activeBroadcast struct {
+	BroadcastID int       `json:"broadcast_id,omitempty"`
+	UploadURL   string    `json:"upload_url"`
+	StartTime   time.Time `json:"start_time"`
+}
+
+// describe names the active broadcast in log messages. Streams to
+// destinations have no broadcast ID.
+func (a activeBroadcast) describe() string {
+	if a.BroadcastID == 0 {
+		return "stream to a destination"
+	}
+	return fmt.Sprintf("broadcast %d", a.BroadcastID)
+}
+
+// recoveryState mirrors the active broadcasts of every stream in the state
+// file.
+type recoveryState struct {
+	mux        sync.Mutex
+	broadcasts map[string]activeBroadcast
+	// frozen is set once broadcastd gives up stopping, after which the
+	// state file is no longer changed.
+	frozen bool
+}
+
+func newRecoveryState() *recoveryState {
+	return &recoveryState{
+		broadcasts: make(map[string]activeBroadcast),
+	}
+}
+
+// load reads the state file, which does not exist after a clean shutdown.
+func (r *recoveryState) load(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return json.Unmarshal(b, &r.broadcasts)
+}
+
+// set records the active broadcast of a stream, or removes it if active is
+// nil, and writes the state file.
+func (r *recoveryState) set(path string, name string, active *activeBroadcast) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	if r.frozen {
+		return nil
+	}
+	if active != nil {
+		r.broadcasts[name] = *active
+	} else {
+		if _, ok := r.broadcasts[name]; !ok {
+			return nil
+		}
+		delete(r.broadcasts, name)
+	}
+	return r.write(path)
+}
+
+// freeze writes the state file a last time and ignores later changes, so
+// that broadcasts that are still being stopped when broadcastd exits are
+// recovered on the next start.
+func (r *recoveryState) freeze(path string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	r.frozen = true
+	return r.write(path)
+}
+
+// write writes the state file. r.mux must be held.
+func (r *recoveryState) write(path string) error {
+	if path == "" {
+		return nil
+	}
+	if len(r.broadcasts) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	b, err := json.MarshalIndent(r.broadcasts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Upload URLs carry stream keys.
+	return writeFileAtomic(path, b, 0600)
+}
+
+// get returns the active broadcast of a stream.
+func (r *recoveryState) get(name string) (activeBroadcast, bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	active, ok := r.broadcasts[name]
+	return active, ok
+}
+
+func (r *recoveryState) snapshot() map[string]activeBroadcast {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	broadcasts := make(map[string]activeBroadcast, len(r.broadcasts))
+	for name, active := range r.broadcasts {
+		broadcasts[name] = active
+	}
+	return broadcasts
+}
+
+// setActiveBroadcast persists the active broadcast of a stream.
+func (b *Broadcast) setActiveBroadcast(name string, active *activeBroadcast) {
+	if err := b.recovery.set(b.Config().Recovery.StateFile, name, active); err != nil {
+		log.Errorf("broadcast: unable to write recovery state: %v", err)
+	}
+}
+
+// loadRecovery reads the broadcasts that were active when broadcastd last
+// exited without stopping them. It must be called before any stream is
+// started, which would replace the state file.
+func (b *Broadcast) loadRecovery() map[string]activeBroadcast {
+	path := b.Config().Recovery.StateFile
+	if path == "" {
+		return nil
+	}
+
+	if err := b.recovery.load(path); err != nil {
+		log.Errorf("broadcast: unable to read recovery state: %v", err)
+		return nil
+	}
+	return b.recovery.snapshot()
+}
+
+// recover resumes or ends the given broadcasts.
+func (b *Broadcast) recover(broadcasts map[string]activeBroadcast) {
+	config := b.Config().Recovery
+
+	g, _ := errgroup.WithContext(context.Background())
+	for name, active := range broadcasts {
+		stream, ok := b.stream(name)
+		if !ok {
+			log.Warnf("broadcast: %s is no longer in the config, unable to recover its %s", name, active.describe())
+			b.setActiveBroadcast(name, nil)
+			continue
+		}
+
+		active := active
+		g.Go(func() error {
+			stream.recover(active, config.Action == recoveryResume)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+// recover reattaches the stream to a broadcast that was active before a
+// restart if resume is set and the broadcast is still live. Otherwise it
+// ends the broadcast and runs the post-live actions. The stream cannot be
+// started or stopped meanwhile.
+func (s *Stream) recover(active activeBroadcast, resume bool) {
+	s.streamingMux.Lock()
+	defer s.streamingMux.Unlock()
+
+	if s.IsStreaming() {
+		// The stream ends the broadcast itself before creating another.
+		log.Infof("stream: %s: already started, not recovering its %s", s.name, active.describe())
+		return
+	}
+
+	if s.destination != nil {
+		if !resume {
+			s.broadcast.setActiveBroadcast(s.name, nil)
+			return
+		}
+		log.Infof("stream: %s: resuming stream to destination", s.name)
+		if err := s.start(); err != nil {
+			log.Errorf("stream: %s: unable to resume stream: %v", s.name, err)
+		}
+		return
+	}
+
+	log.Infof("stream: %s: recovering broadcast %d", s.name, active.BroadcastID)
+	s.state.set(loggingIn, "")
+	if err := s.login(); err != nil {
+		log.Errorf("stream: %s: unable to login to recover broadcast %d: %v", s.name, active.BroadcastID, err)
+		s.state.fail(loginError, err)
+		return
+	}
+	s.loginRequired = false
+	s.state.set(ready, fmt.Sprintf("recovering broadcast %d", active.BroadcastID))
+	s.setBroadcast(active.BroadcastID, active.UploadURL, active.StartTime)
+
+	// Transient errors are already retried by the client.
+	info, err := s.instagram.Live.Info(active.BroadcastID)
+	if err != nil && resume {
+		// The broadcast may still be live. It is kept, and ended when the
+		// stream is started again or recovered after the next restart.
+		log.Errorf("stream: %s: unable to get broadcast %d, not ending it: %v", s.name, active.BroadcastID, err)
+		s.state.fail(ready, err)
+		return
+	}
+	if err != nil {
+		log.Errorf("stream: %s: unable to get broadcast %d: %v", s.name, active.BroadcastID, err)
+	}
+	stopped := err == nil && info.BroadcastStatus == "stopped"
+
+	if resume && !stopped {
+		log.Infof("stream: %s: resuming broadcast %d", s.name, active.BroadcastID)
+		s.resuming = true
+		if err := s.start(); err != nil {
+			log.Errorf("stream: %s: unable to resume broadcast %d: %v", s.name, active.BroadcastID, err)
+		}
+		return
+	}
+
+	s.state.set(posting, "")
+	if stopped {
+		log.Infof("stream: %s: broadcast %d has already stopped", s.name, active.BroadcastID)
+		s.postLive()
+		s.broadcast.setActiveBroadcast(s.name, nil)
+	} else {
+		s.endBroadcastAndPost()
+	}
+	s.state.set(ready, "")
+}
@@ -0,0 +1,99 @@
+package broadcast
+
+import (
+	"encoding/json"
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecoveryStateFreeze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broadcastd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	r := newRecoveryState()
+	if err := r.set(path, "alice", &activeBroadcast{BroadcastID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.set(path, "bob", &activeBroadcast{BroadcastID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.freeze(path); err != nil {
+		t.Fatal(err)
+	}
+	// Broadcasts that end after giving up are still recovered.
+	if err := r.set(path, "alice", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var broadcasts map[string]activeBroadcast
+	if err := json.Unmarshal(b, &broadcasts); err != nil {
+		t.Fatal(err)
+	}
+	if len(broadcasts) != 2 || broadcasts["alice"].BroadcastID != 1 || broadcasts["bob"].BroadcastID != 2 {
+		t.Errorf("state file has %v, want both broadcasts", broadcasts)
+	}
+}
+
+func TestRecoverWhileStarting(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	s, _ := b.stream("alice")
+	b.setActiveBroadcast("alice", &activeBroadcast{BroadcastID: 42, StartTime: time.Now()})
+
+	done := make(chan struct{})
+	go func() {
+		b.recover(b.recovery.snapshot())
+		close(done)
+	}()
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+
+	waitFor(t, "a broadcast", func() bool {
+		return len(ig.Broadcasts()) == 1 && s.state.current() == streaming
+	})
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsStreaming() {
+		t.Error("stream is still streaming after it was stopped")
+	}
+}
+
+func TestRecoverKeepsBroadcastWhenInfoFails(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	s, _ := b.stream("alice")
+	b.setActiveBroadcast("alice", &activeBroadcast{BroadcastID: 42, StartTime: time.Now()})
+
+	fail := fakeig.Fail(500, "unavailable")
+	ig.Enqueue(fakeig.RouteLiveInfo, fail, fail, fail)
+	b.recover(b.recovery.snapshot())
+
+	if calls := ig.Calls(fakeig.RouteLiveEnd); calls != 0 {
+		t.Errorf("end_broadcast called %d times, want 0", calls)
+	}
+	if active, ok := b.recovery.get("alice"); !ok || active.BroadcastID != 42 {
+		t.Error("broadcast 42 is not kept after its info could not be read")
+	}
+	if state := s.state.current(); state != ready {
+		t.Errorf("state = %s, want %s", state, ready)
+	}
+}
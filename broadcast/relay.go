@@ -0,0 +1,165 @@
+package broadcast
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/sbekti/broadcastd/flv"
+	"github.com/sbekti/broadcastd/rtmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	ingestRestartDelay = 5 * time.Second
+)
+
+// Relay pulls the input once and fans the resulting FLV stream out to any
+// number of subscribers, so that every output shares a single decode and
+// encode of the input. The input is either Config.InputURL or, when the
+// built-in ingest server is enabled, whatever is published to it.
+type Relay struct {
+	config *Config
+	source *rtmp.Server
+	hub    *flv.Hub
+
+	lifecycleMux sync.Mutex
+	refs         int
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+func NewRelay(config *Config, source *rtmp.Server) *Relay {
+	return &Relay{
+		config: config,
+		source: source,
+		hub:    flv.NewHub(),
+	}
+}
+
+// Acquire starts the ingest stage if this is the first user of the relay.
+func (r *Relay) Acquire() {
+	r.lifecycleMux.Lock()
+	defer r.lifecycleMux.Unlock()
+
+	r.refs++
+	if r.refs > 1 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+	go r.run(ctx, r.done)
+}
+
+// Release stops the ingest stage once the last user of the relay is gone.
+func (r *Relay) Release() {
+	r.lifecycleMux.Lock()
+	defer r.lifecycleMux.Unlock()
+
+	if r.refs == 0 {
+		return
+	}
+
+	r.refs--
+	if r.refs > 0 {
+		return
+	}
+
+	r.cancel()
+	<-r.done
+}
+
+func (r *Relay) Subscribe() *flv.Subscription {
+	return r.hub.Subscribe()
+}
+
+func (r *Relay) Unsubscribe(sub *flv.Subscription) {
+	r.hub.Unsubscribe(sub)
+}
+
+func (r *Relay) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		if err := r.ingest(ctx); err != nil && ctx.Err() == nil {
+			log.Errorf("relay: ingest error: %v", err)
+		}
+
+		// Every output is restarted along with the ingest.
+		r.hub.Reset()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ingestRestartDelay):
+		}
+	}
+}
+
+func (r *Relay) ingest(ctx context.Context) error {
+	var args []string
+	if r.source != nil {
+		args = append(args, "-f", "flv", "-i", "pipe:0")
+	} else {
+		args = append(args, "-i", r.config.InputURL)
+	}
+	args = append(args, r.config.Encoder.Args...)
+	args = append(args, "-f", "flv", "pipe:1")
+
+	cmd := exec.CommandContext(ctx, r.config.Encoder.Command, args...)
+	cmd.Stderr = os.Stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	var sub *flv.Subscription
+	var stdin io.WriteCloser
+	if r.source != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return err
+		}
+		sub = r.source.Subscribe()
+		defer r.source.Unsubscribe(sub)
+	}
+
+	log.Debugf("relay: starting ingest process")
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	log.Infof("relay: ingest process started")
+
+	if sub != nil {
+		// The process waits on stdin until something is published, and
+		// exits once the publisher goes away.
+		go func() {
+			if _, err := sub.WriteTo(stdin); err != nil {
+				log.Debugf("relay: ingest input closed: %v", err)
+			}
+			stdin.Close()
+		}()
+	}
+
+	_, readErr := r.hub.ReadFrom(bufio.NewReader(stdout))
+	if readErr != nil && readErr != io.EOF {
+		// Make sure the process does not linger on a broken stream.
+		_ = cmd.Process.Kill()
+	}
+
+	waitErr := cmd.Wait()
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Debugf("relay: ingest process killed by context cancellation")
+		return nil
+	}
+	if readErr != nil && readErr != io.EOF {
+		return readErr
+	}
+	return waitErr
+}
@@ -0,0 +1,232 @@
+package broadcast
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// restartKeys are the top-level config keys that only take effect after a
+// restart. Changes to them are reported and otherwise ignored.
+var restartKeys = map[string]bool{
+	"input_url": true,
+	"ingest":    true,
+	"auto_live": true,
+	"bind_ip":   true,
+	"bind_port": true,
+	"encoder":   true,
+	"recovery":  true,
+	// Logged in clients keep the options they were created with.
+	"instagram":        true,
+	"credential_store": true,
+}
+
+// ReloadReport describes what changed when the config was reloaded.
+type ReloadReport struct {
+	// Applied are the top-level config keys that changed and have been
+	// applied.
+	Applied []string `json:"applied"`
+	// RestartRequired are the changes that have not been applied.
+	RestartRequired []string `json:"restart_required"`
+	// Added, Removed and Updated are the names of the affected streams.
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+}
+
+// Reload loads the config file again and applies it to the running
+// broadcast. Idle streams are added and removed as needed. Settings of
+// streams apply to their next broadcast, except for the announcement
+// message, which is read when it is posted.
+func (b *Broadcast) Reload() (*ReloadReport, error) {
+	b.reloadMux.Lock()
+	defer b.reloadMux.Unlock()
+
+	old := b.Config()
+	c, err := LoadConfig(old.path)
+	if err != nil {
+		return nil, err
+	}
+
+	level, err := log.ParseLevel(c.LogLevel)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+
+	if c.Accounts == nil {
+		c.Accounts = make(map[string]*Account)
+	}
+	if c.Destinations == nil {
+		c.Destinations = make(map[string]*Destination)
+	}
+
+	report := &ReloadReport{
+		Applied:         []string{},
+		RestartRequired: []string{},
+		Added:           []string{},
+		Removed:         []string{},
+		Updated:         []string{},
+	}
+	if c.CredentialStore != old.CredentialStore {
+		// The old store is kept until a restart, like its settings.
+		c.store = old.store
+	}
+	report.diffKeys(old, c)
+
+	existing := make(map[string]bool)
+	var removed, updated []*Stream
+
+	for _, stream := range b.streamList() {
+		existing[stream.name] = true
+
+		if stream.destination == nil {
+			if account, ok := c.Accounts[stream.name]; ok {
+				oldAccount := old.Accounts[stream.name]
+				if account.Proxy != oldAccount.Proxy {
+					// A logged in client keeps the transport it was
+					// created with.
+					account.Proxy = oldAccount.Proxy
+					account.proxyURL = oldAccount.proxyURL
+					report.RestartRequired = append(report.RestartRequired,
+						fmt.Sprintf("accounts.%s.proxy", stream.name))
+				}
+				if accountChanged(oldAccount, account) ||
+					old.StreamSettings(stream.name) != c.StreamSettings(stream.name) {
+					updated = append(updated, stream)
+				}
+				continue
+			}
+		} else if destination, ok := c.Destinations[stream.name]; ok {
+			if *destination == *stream.destination {
+				continue
+			}
+			if stream.IsStreaming() {
+				// Keep pushing to the current URL until the stream is stopped.
+				c.Destinations[stream.name] = old.Destinations[stream.name]
+				report.RestartRequired = append(report.RestartRequired,
+					fmt.Sprintf("destinations.%s: still streaming, stop it and reload again", stream.name))
+				continue
+			}
+			updated = append(updated, stream)
+			continue
+		}
+
+		// The stream is gone from the config, or has changed its type.
+		if !stream.remove() {
+			if stream.destination == nil {
+				c.Accounts[stream.name] = old.Accounts[stream.name]
+				delete(c.Destinations, stream.name)
+			} else {
+				c.Destinations[stream.name] = old.Destinations[stream.name]
+				delete(c.Accounts, stream.name)
+			}
+			report.RestartRequired = append(report.RestartRequired,
+				fmt.Sprintf("%s: still streaming, stop it and reload again", stream.name))
+			continue
+		}
+		removed = append(removed, stream)
+	}
+
+	b.configMux.Lock()
+	b.config = c
+	b.configMux.Unlock()
+
+	log.SetLevel(level)
+	b.sessions.setTTL(time.Duration(c.Auth.SessionTTL) * time.Hour)
+
+	b.streamsMux.Lock()
+	for _, stream := range removed {
+		delete(b.streams, stream.name)
+		report.Removed = append(report.Removed, stream.name)
+		existing[stream.name] = false
+	}
+	for name := range c.Accounts {
+		if !existing[name] {
+			b.streams[name] = NewStream(name, c, b)
+			report.Added = append(report.Added, name)
+		}
+	}
+	for name, destination := range c.Destinations {
+		if !existing[name] {
+			b.streams[name] = NewDestinationStream(name, destination, c, b)
+			report.Added = append(report.Added, name)
+		}
+	}
+	b.streamsMux.Unlock()
+
+	for _, stream := range updated {
+		if stream.destination != nil && !stream.setDestination(c.Destinations[stream.name]) {
+			report.RestartRequired = append(report.RestartRequired,
+				fmt.Sprintf("destinations.%s: still streaming, stop it and reload again", stream.name))
+			continue
+		}
+		stream.applyConfig(c)
+		report.Updated = append(report.Updated, stream.name)
+	}
+
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.Updated)
+
+	log.Infof("broadcast: reloaded config: %s", report)
+	for _, change := range report.RestartRequired {
+		log.Warnf("broadcast: config change requires a restart: %s", change)
+	}
+
+	return report, nil
+}
+
+// accountChanged reports whether the settings of an account differ, not
+// counting its token, which is saved to the file while broadcastd runs.
+func accountChanged(old *Account, c *Account) bool {
+	a, b := *old, *c
+	a.Token, b.Token = "", ""
+	return !reflect.DeepEqual(a, b)
+}
+
+// diffKeys compares the top-level keys of two configs. Keys that require a
+// restart are set back to their old value in c.
+func (r *ReloadReport) diffKeys(old *Config, c *Config) {
+	oldValue := reflect.ValueOf(old).Elem()
+	newValue := reflect.ValueOf(c).Elem()
+	t := oldValue.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "accounts" || key == "destinations" {
+			continue
+		}
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+
+		if restartKeys[key] {
+			newValue.Field(i).Set(oldValue.Field(i))
+			r.RestartRequired = append(r.RestartRequired, key)
+			continue
+		}
+		r.Applied = append(r.Applied, key)
+	}
+}
+
+func (r *ReloadReport) String() string {
+	var parts []string
+	add := func(label string, values []string) {
+		if len(values) > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", label, strings.Join(values, ", ")))
+		}
+	}
+	add("applied", r.Applied)
+	add("added", r.Added)
+	add("removed", r.Removed)
+	add("updated", r.Updated)
+	add("restart required", r.RestartRequired)
+
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
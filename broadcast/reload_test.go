@@ -0,0 +1,188 @@
+package broadcast
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// editConfig replaces old with new in the config file of b.
+func editConfig(t *testing.T, b *Broadcast, old string, new string) {
+	t.Helper()
+
+	path := b.Config().path
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%q is not in the config", old)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadKeepsProxy(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	editConfig(t, b, "password: 'secret'", "password: 'secret'\n    proxy: 'http://127.0.0.1:3128'")
+	c, err := LoadConfig(b.Config().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = newBroadcast(c)
+	s, _ := b.stream("alice")
+
+	editConfig(t, b, "http://127.0.0.1:3128", "socks5://127.0.0.1:1080")
+	editConfig(t, b, "poll_interval: 1", "poll_interval: 2")
+	report, err := b.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !contains(report.RestartRequired, "accounts.alice.proxy") {
+		t.Errorf("restart required = %v, want the proxy of alice", report.RestartRequired)
+	}
+	if contains(report.Updated, "alice") {
+		t.Errorf("alice was updated although only its proxy changed")
+	}
+	if proxy := b.Config().Accounts["alice"].Proxy; proxy != "http://127.0.0.1:3128" {
+		t.Errorf("proxy in the config = %s, want the old proxy", proxy)
+	}
+	if proxy := s.Info().Proxy; proxy != "http://127.0.0.1:3128" {
+		t.Errorf("proxy of the stream = %s, want the old proxy", proxy)
+	}
+	if b.Config().PollInterval != 2 {
+		t.Errorf("other changes were not applied")
+	}
+}
+
+func TestReloadKeepsInstagramOptions(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	dir := filepath.Dir(b.Config().path)
+	editConfig(t, b, "max_backoff: 10", "max_backoff: 20")
+	editConfig(t, b, "poll_interval: 1", "poll_interval: 2\ncredential_store:\n  path: '"+filepath.Join(dir, "credentials.enc")+"'")
+	os.Setenv(storeKeyEnv, base64.StdEncoding.EncodeToString(testStoreKey(1)))
+	defer os.Unsetenv(storeKeyEnv)
+
+	report, err := b.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"instagram", "credential_store"} {
+		if !contains(report.RestartRequired, key) {
+			t.Errorf("restart required = %v, want %s", report.RestartRequired, key)
+		}
+		if contains(report.Applied, key) {
+			t.Errorf("applied = %v, want %s to require a restart", report.Applied, key)
+		}
+	}
+	c := b.Config()
+	if c.Instagram.Retry.MaxBackoff != 10 {
+		t.Errorf("max_backoff = %d, want the old value", c.Instagram.Retry.MaxBackoff)
+	}
+	if c.CredentialStore.Path != "" || c.store != nil {
+		t.Error("credential store was enabled by reloading")
+	}
+	if c.PollInterval != 2 {
+		t.Errorf("other changes were not applied")
+	}
+}
+
+func TestSaveTokenWhileReading(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	c := b.Config()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := c.SaveToken("alice", "token"); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c.Credentials("alice")
+			if _, err := c.RedactedYAML(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if token := c.Credentials("alice").Token; token != "token" {
+		t.Fatalf("token = %q, want the saved token", token)
+	}
+	report, err := b.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(report.Updated, "alice") {
+		t.Error("alice was updated by reloading the token it saved")
+	}
+}
+
+func TestSaveTokensConcurrently(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	editConfig(t, b, "password: 'secret'", "password: 'secret'\n  bob:\n    password: 'secret'")
+	c, err := LoadConfig(b.Config().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		var wg sync.WaitGroup
+		for _, name := range []string{"alice", "bob"} {
+			name := name
+			token := fmt.Sprintf("%s-%d", name, i)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.SaveToken(name, token); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		saved, err := LoadConfig(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"alice", "bob"} {
+			if token, want := saved.Accounts[name].Token, fmt.Sprintf("%s-%d", name, i); token != want {
+				t.Fatalf("token of %s in the file = %q, want %q", name, token, want)
+			}
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package broadcast
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	secretCommandTimeout = 10 * time.Second
+	// literalPrefix marks a literal value that would otherwise look like a
+	// reference to a secret provider, e.g. literal:env:abc.
+	literalPrefix = "literal:"
+)
+
+// secretProviders resolve config values of the form <provider>:<argument>,
+// so that secrets do not have to be written into the config file.
+var secretProviders = map[string]func(arg string) (string, error){
+	"env":  envSecret,
+	"file": fileSecret,
+	"exec": execSecret,
+}
+
+// isSecretRef reports whether value refers to a secret provider.
+func isSecretRef(value string) bool {
+	i := strings.IndexByte(value, ':')
+	if i < 0 {
+		return false
+	}
+	_, ok := secretProviders[value[:i]]
+	return ok
+}
+
+// resolveSecret returns the secret value refers to, or value itself if it
+// is a literal.
+func resolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, literalPrefix) {
+		return value[len(literalPrefix):], nil
+	}
+	if !isSecretRef(value) {
+		return value, nil
+	}
+	i := strings.IndexByte(value, ':')
+	return secretProviders[value[:i]](value[i+1:])
+}
+
+func envSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+func fileSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func execSecret(command string) (string, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command given")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %v: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", args[0], err)
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// splitCommand splits a command line into arguments like a POSIX shell
+// does, but without any expansion: arguments are separated by whitespace,
+// which can be kept in single or double quotes or escaped with a
+// backslash.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range command {
+		switch {
+		case escaped:
+			// Inside double quotes, a backslash only escapes " and \.
+			if quote == '"' && r != '"' && r != '\\' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped = true
+			inArg = true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		return nil, fmt.Errorf("command ends with a backslash")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
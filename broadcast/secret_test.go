@@ -0,0 +1,122 @@
+package broadcast
+
+import (
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		err     string
+	}{
+		{"pass show instagram/alice", []string{"pass", "show", "instagram/alice"}, ""},
+		{"  cat \t /run/secret  ", []string{"cat", "/run/secret"}, ""},
+		{`cat "/run/my secrets/ig"`, []string{"cat", "/run/my secrets/ig"}, ""},
+		{`cat '/run/my secrets/ig'`, []string{"cat", "/run/my secrets/ig"}, ""},
+		{`cat /run/my\ secrets/ig`, []string{"cat", "/run/my secrets/ig"}, ""},
+		{`echo 'it'"'"'s'`, []string{"echo", "it's"}, ""},
+		{`echo "a \"b\" \\ \$c"`, []string{"echo", `a "b" \ \$c`}, ""},
+		{`echo 'a \ b'`, []string{"echo", `a \ b`}, ""},
+		{`echo "" ''`, []string{"echo", "", ""}, ""},
+		{"", nil, ""},
+		{`cat "/run/secret`, nil, "unterminated \" quote"},
+		{`cat '/run/secret`, nil, "unterminated ' quote"},
+		{`cat /run/secret\`, nil, "ends with a backslash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, err := splitCommand(tt.command)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("splitCommand(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broadcastd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "my secret")
+	if err := ioutil.WriteFile(path, []byte("from file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("BROADCASTD_TEST_SECRET", "from env")
+	defer os.Unsetenv("BROADCASTD_TEST_SECRET")
+
+	tests := []struct {
+		value string
+		want  string
+		err   bool
+	}{
+		{"plain", "plain", false},
+		{"unknown:value", "unknown:value", false},
+		{"env:BROADCASTD_TEST_SECRET", "from env", false},
+		{"env:BROADCASTD_TEST_UNSET", "", true},
+		{"file:" + path, "from file", false},
+		{"file:" + filepath.Join(dir, "missing"), "", true},
+		{"exec:cat '" + path + "'", "from file", false},
+		{"exec:printf '%s\\n' 'a b'", "a b", false},
+		{"exec:false", "", true},
+		{"exec:", "", true},
+		{"literal:env:BROADCASTD_TEST_SECRET", "env:BROADCASTD_TEST_SECRET", false},
+		{"literal:literal:x", "literal:x", false},
+		{"literal:", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := resolveSecret(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("resolveSecret(%q) = %q, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("resolveSecret(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnresolvedSecret(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	editConfig(t, b, "password: 'secret'", "password: 'env:BROADCASTD_TEST_UNSET'")
+	os.Unsetenv("BROADCASTD_TEST_UNSET")
+
+	_, err := LoadConfig(b.Config().path)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	if len(verr.Problems) != 1 || !strings.HasPrefix(verr.Problems[0], "accounts.alice.password: unable to resolve") {
+		t.Errorf("got problems %q, want only the unresolved password", verr.Problems)
+	}
+}
@@ -40,6 +40,7 @@ func NewServer(b *Broadcast, ip string, port int) *Server {
 	e.Logger = logrusLogger{Logger: logrus.StandardLogger()}
 	e.Use(loggerHook())
 	e.Use(stateMiddleware(b))
+	e.Use(metricsMiddleware(b.metrics))
 	e.Use(middleware.Recover())
 
 	assetHandler := http.FileServer(http.Dir("public/static"))
@@ -50,14 +51,32 @@ func NewServer(b *Broadcast, ip string, port int) *Server {
 	}
 	e.Renderer = t
 
-	e.GET("/", GetIndex)
-	e.GET("/:account/security_code", GetSecurityCode)
-	e.POST("/:account/security_code", PostSecurityCode)
-	e.GET("/comments", GetComments)
-	e.GET("/ws/comments", WebSocketComments)
+	dashboard := dashboardAuth(b)
+	readOnly := readOnlyAuth(b)
 
-	g := e.Group("/api/v1")
+	e.GET("/login", GetLogin)
+	e.POST("/login", PostLogin)
+	e.POST("/logout", PostLogout)
+
+	e.GET("/", GetIndex, dashboard)
+	e.GET("/:account/security_code", GetSecurityCode, dashboard)
+	e.POST("/:account/security_code", PostSecurityCode, dashboard)
+	e.GET("/comments", GetComments, readOnly)
+	e.GET("/ws/comments", WebSocketComments, readOnly)
+	e.GET("/ws/status", WebSocketStatus, apiAuth(b))
+
+	e.GET("/metrics", b.metrics.handler(), apiAuth(b))
+
+	g := e.Group("/api/v1", apiAuth(b))
+	g.GET("/status", GetStatus)
 	g.POST("/live", PostLive)
+	g.GET("/streams", GetStreams)
+	g.GET("/streams/:account", GetStream)
+	g.GET("/streams/:account/viewers", GetStreamViewers)
+	g.GET("/viewers", GetViewers)
+	g.POST("/streams/:account/live", PostStreamLive)
+	g.POST("/streams/:account/security_code", PostStreamSecurityCode)
+	g.POST("/config/reload", PostConfigReload)
 
 	return &Server{
 		IP:   ip,
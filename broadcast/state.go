@@ -0,0 +1,202 @@
+package broadcast
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	maxStateHistory = 50
+)
+
+// State is the state of a stream in its event loop.
+type State string
+
+const (
+	ready                State = "Ready"
+	loggingIn            State = "Logging in"
+	loginError           State = "Login error"
+	challengeRequired    State = "Challenge required"
+	challengeError       State = "Challenge error"
+	creatingBroadcast    State = "Creating broadcast"
+	createBroadcastError State = "Create broadcast error"
+	streaming            State = "Streaming"
+	encoderRestart       State = "Encoder restart"
+	posting              State = "Posting"
+)
+
+// validTransitions lists the states that may follow each state. Every
+// state may go back to ready when the stream is stopped. A broadcast that
+// is recovered after a restart goes from ready to streaming or posting.
+var validTransitions = map[State][]State{
+	ready:                {loggingIn, creatingBroadcast, streaming, posting},
+	loggingIn:            {challengeRequired, loginError, creatingBroadcast},
+	loginError:           {loggingIn},
+	challengeRequired:    {challengeError, creatingBroadcast},
+	challengeError:       {loggingIn},
+	creatingBroadcast:    {streaming, createBroadcastError, loggingIn},
+	createBroadcastError: {creatingBroadcast, loggingIn},
+	streaming:            {encoderRestart, posting, loggingIn},
+	encoderRestart:       {streaming, posting, loggingIn},
+	posting:              {creatingBroadcast, loggingIn},
+}
+
+type Transition struct {
+	From   State     `json:"from"`
+	To     State     `json:"to"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// StateInfo is a snapshot of a stream state machine.
+type StateInfo struct {
+	State         State        `json:"state"`
+	Since         time.Time    `json:"since"`
+	LastError     string       `json:"last_error,omitempty"`
+	LastErrorTime *time.Time   `json:"last_error_time,omitempty"`
+	Retries       int          `json:"retries"`
+	History       []Transition `json:"history"`
+}
+
+// stateMachine tracks the state of a stream. It is written from the event
+// loop goroutines and read from the HTTP handlers, so every access goes
+// through its mutex.
+type stateMachine struct {
+	name          string
+	onTransition  func(Transition)
+	mux           sync.RWMutex
+	state         State
+	since         time.Time
+	lastError     string
+	lastErrorTime time.Time
+	retries       int
+	history       []Transition
+}
+
+func newStateMachine(name string, onTransition func(Transition)) *stateMachine {
+	return &stateMachine{
+		name:         name,
+		onTransition: onTransition,
+		state:        ready,
+		since:        time.Now(),
+	}
+}
+
+func (m *stateMachine) current() State {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	return m.state
+}
+
+// set moves to the given state. Moving to the current state is a no-op.
+func (m *stateMachine) set(to State, reason string) {
+	m.mux.Lock()
+	t, ok := m.transition(to, reason)
+	m.mux.Unlock()
+
+	if ok {
+		m.notify(t)
+	}
+}
+
+// fail moves to the given error state, recording err as the last error and
+// counting a retry.
+func (m *stateMachine) fail(to State, err error) {
+	m.mux.Lock()
+	m.lastError = err.Error()
+	m.lastErrorTime = time.Now()
+	m.retries++
+	t, ok := m.transition(to, m.lastError)
+	m.mux.Unlock()
+
+	if ok {
+		m.notify(t)
+	}
+}
+
+// notify runs the transition callback outside of the lock, so that it can
+// read the state machine.
+func (m *stateMachine) notify(t Transition) {
+	if m.onTransition != nil {
+		m.onTransition(t)
+	}
+}
+
+// recordError records err as the last error without changing the state,
+// for errors that are retried in place such as failed heartbeats.
+func (m *stateMachine) recordError(err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.lastError = err.Error()
+	m.lastErrorTime = time.Now()
+}
+
+func (m *stateMachine) resetRetries() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.retries = 0
+}
+
+func (m *stateMachine) transition(to State, reason string) (Transition, bool) {
+	from := m.state
+	if from == to {
+		return Transition{}, false
+	}
+
+	if !isValidTransition(from, to) {
+		log.Warnf("stream: %s: unexpected state transition from %s to %s", m.name, from, to)
+	}
+	log.Debugf("stream: %s: state: %s -> %s", m.name, from, to)
+
+	now := time.Now()
+	m.state = to
+	m.since = now
+
+	t := Transition{
+		From:   from,
+		To:     to,
+		Time:   now,
+		Reason: reason,
+	}
+	m.history = append(m.history, t)
+	if len(m.history) > maxStateHistory {
+		m.history = m.history[len(m.history)-maxStateHistory:]
+	}
+	return t, true
+}
+
+func (m *stateMachine) info() StateInfo {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	info := StateInfo{
+		State:     m.state,
+		Since:     m.since,
+		LastError: m.lastError,
+		Retries:   m.retries,
+		History:   make([]Transition, len(m.history)),
+	}
+	copy(info.History, m.history)
+
+	if !m.lastErrorTime.IsZero() {
+		lastErrorTime := m.lastErrorTime
+		info.LastErrorTime = &lastErrorTime
+	}
+
+	return info
+}
+
+func isValidTransition(from State, to State) bool {
+	if to == ready {
+		return true
+	}
+	for _, s := range validTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
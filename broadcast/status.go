@@ -0,0 +1,65 @@
+package broadcast
+
+import (
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	statusEventSnapshot       = "snapshot"
+	statusEventState          = "state"
+	statusEventEncoderRestart = "encoder_restart"
+	statusEventViewers        = "viewers"
+	statusEventLive           = "live"
+
+	statusSubscriberBufSize = 64
+)
+
+// StatusEvent is pushed to /ws/status subscribers whenever a stream changes.
+// Every event carries a full snapshot of the stream, so a subscriber that
+// misses an event catches up on the next one.
+type StatusEvent struct {
+	Event      string      `json:"event"`
+	Time       time.Time   `json:"time"`
+	Stream     StreamInfo  `json:"stream"`
+	Transition *Transition `json:"transition,omitempty"`
+}
+
+func (b *Broadcast) subscribeStatus() chan StatusEvent {
+	ch := make(chan StatusEvent, statusSubscriberBufSize)
+
+	b.statusSubscribersMux.Lock()
+	defer b.statusSubscribersMux.Unlock()
+
+	b.statusSubscribers[ch] = struct{}{}
+	return ch
+}
+
+func (b *Broadcast) unsubscribeStatus(ch chan StatusEvent) {
+	b.statusSubscribersMux.Lock()
+	defer b.statusSubscribersMux.Unlock()
+
+	delete(b.statusSubscribers, ch)
+}
+
+// publishStatus sends an event to every subscriber without blocking the
+// stream, dropping it for subscribers that are not keeping up.
+func (b *Broadcast) publishStatus(event string, s *Stream, transition *Transition) {
+	e := StatusEvent{
+		Event:      event,
+		Time:       time.Now(),
+		Stream:     s.Info(),
+		Transition: transition,
+	}
+
+	b.statusSubscribersMux.RLock()
+	defer b.statusSubscribersMux.RUnlock()
+
+	for ch := range b.statusSubscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Debugf("ws: dropping status event for slow subscriber")
+		}
+	}
+}
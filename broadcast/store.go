@@ -0,0 +1,139 @@
+package broadcast
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	storeKeyEnv = "BROADCASTD_STORE_KEY"
+	storeKeyLen = 32
+)
+
+// storeAdditionalData binds the ciphertext to this file format.
+var storeAdditionalData = []byte("broadcastd credential store v1")
+
+// Credentials are the secrets of an account kept in the credential store.
+type Credentials struct {
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// credentialStore keeps account credentials in a file encrypted with
+// AES-256-GCM, so that they do not have to be kept in the config file.
+type credentialStore struct {
+	path string
+	aead cipher.AEAD
+
+	mux      sync.Mutex
+	accounts map[string]Credentials
+}
+
+// loadStoreKey reads the base64-encoded 256-bit key of the credential store
+// from the BROADCASTD_STORE_KEY environment variable, or else from keyFile.
+func loadStoreKey(keyFile string) ([]byte, error) {
+	encoded := os.Getenv(storeKeyEnv)
+	if encoded == "" {
+		if keyFile == "" {
+			return nil, fmt.Errorf("no key in %s and no key_file", storeKeyEnv)
+		}
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(b)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %v", err)
+	}
+	if len(key) != storeKeyLen {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", storeKeyLen, len(key))
+	}
+	return key, nil
+}
+
+// openCredentialStore opens the store at path, which does not have to
+// exist yet.
+func openCredentialStore(path string, key []byte) (*credentialStore, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &credentialStore{
+		path:     path,
+		aead:     aead,
+		accounts: make(map[string]Credentials),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], storeAdditionalData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt %s, is the key correct?", path)
+	}
+	if err := json.Unmarshal(plaintext, &s.accounts); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *credentialStore) get(name string) (Credentials, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	credentials, ok := s.accounts[name]
+	return credentials, ok
+}
+
+// update changes the credentials of an account and writes the store.
+func (s *credentialStore) update(name string, fn func(credentials *Credentials)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	credentials := s.accounts[name]
+	fn(&credentials)
+	s.accounts[name] = credentials
+
+	return s.save()
+}
+
+// save encrypts the store and replaces the file atomically.
+func (s *credentialStore) save() error {
+	plaintext, err := json.Marshal(s.accounts)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := s.aead.Seal(nonce, nonce, plaintext, storeAdditionalData)
+
+	return writeFileAtomic(s.path, data, 0600)
+}
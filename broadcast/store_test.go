@@ -0,0 +1,260 @@
+package broadcast
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testStoreKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, storeKeyLen)
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "broadcastd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// newTestStore returns the path of a store with a single account.
+func newTestStore(t *testing.T, key []byte) string {
+	t.Helper()
+
+	path := filepath.Join(tempDir(t), "credentials.enc")
+	s, err := openCredentialStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.update("alice", func(credentials *Credentials) {
+		credentials.Password = "secret"
+		credentials.Token = "token"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCredentialStoreRoundTrip(t *testing.T) {
+	key := testStoreKey(1)
+	path := newTestStore(t, key)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("alice")) {
+		t.Error("store is not encrypted")
+	}
+
+	s, err := openCredentialStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials, ok := s.get("alice")
+	if !ok || credentials != (Credentials{Password: "secret", Token: "token"}) {
+		t.Errorf("got %+v, want the saved credentials", credentials)
+	}
+	if _, ok := s.get("bob"); ok {
+		t.Error("got credentials of an account that was not saved")
+	}
+}
+
+func TestCredentialStoreMissingFile(t *testing.T) {
+	path := filepath.Join(tempDir(t), "credentials.enc")
+	s, err := openCredentialStore(path, testStoreKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.get("alice"); ok {
+		t.Error("got credentials from a store that does not exist")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("opening the store created the file")
+	}
+}
+
+func TestCredentialStoreWrongKey(t *testing.T) {
+	path := newTestStore(t, testStoreKey(1))
+
+	_, err := openCredentialStore(path, testStoreKey(2))
+	if err == nil || !strings.Contains(err.Error(), "is the key correct?") {
+		t.Errorf("error = %v, want a decryption error", err)
+	}
+}
+
+func TestCredentialStoreDamagedFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte) []byte
+		err    string
+	}{
+		{
+			name:   "truncated to less than a nonce",
+			damage: func(data []byte) []byte { return data[:4] },
+			err:    "is truncated",
+		},
+		{
+			name:   "truncated",
+			damage: func(data []byte) []byte { return data[:len(data)-1] },
+			err:    "unable to decrypt",
+		},
+		{
+			name: "corrupted",
+			damage: func(data []byte) []byte {
+				data[len(data)/2] ^= 0xff
+				return data
+			},
+			err: "unable to decrypt",
+		},
+		{
+			name:   "empty",
+			damage: func(data []byte) []byte { return nil },
+			err:    "is truncated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := testStoreKey(1)
+			path := newTestStore(t, key)
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, tt.damage(data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = openCredentialStore(path, key)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCredentialStorePermissions(t *testing.T) {
+	path := newTestStore(t, testStoreKey(1))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("store has permissions %v, want 0600", perm)
+	}
+}
+
+func TestLoadStoreKey(t *testing.T) {
+	dir := tempDir(t)
+	writeKey := func(name string, key []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := writeKey("valid.key", testStoreKey(1))
+	short := writeKey("short.key", testStoreKey(1)[:16])
+	invalid := filepath.Join(dir, "invalid.key")
+	if err := ioutil.WriteFile(invalid, []byte("not base64!"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		keyFile string
+		err     string
+	}{
+		{name: "key file", keyFile: valid},
+		{name: "environment", env: base64.StdEncoding.EncodeToString(testStoreKey(1)), keyFile: short},
+		{name: "wrong length", keyFile: short, err: "key must be 32 bytes, got 16"},
+		{name: "wrong length in the environment", env: base64.StdEncoding.EncodeToString(testStoreKey(1)[:31]), err: "key must be 32 bytes, got 31"},
+		{name: "not base64", keyFile: invalid, err: "key is not valid base64"},
+		{name: "missing key file", keyFile: filepath.Join(dir, "missing.key"), err: "no such file"},
+		{name: "no key", err: "no key in BROADCASTD_STORE_KEY and no key_file"},
+	}
+
+	defer os.Unsetenv(storeKeyEnv)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(storeKeyEnv, tt.env)
+
+			key, err := loadStoreKey(tt.keyFile)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(key, testStoreKey(1)) {
+				t.Errorf("got key %x, want %x", key, testStoreKey(1))
+			}
+		})
+	}
+}
+
+func TestCredentialStorePasswordChange(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	dir := filepath.Dir(b.Config().path)
+	keyFile := filepath.Join(dir, "credentials.key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(testStoreKey(1))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	editConfig(t, b, "poll_interval: 1", "poll_interval: 1\ncredential_store:\n  path: '"+filepath.Join(dir, "credentials.enc")+"'\n  key_file: '"+keyFile+"'")
+
+	c, err := LoadConfig(b.Config().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MigrateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SaveToken("alice", "token"); err != nil {
+		t.Fatal(err)
+	}
+
+	b = newBroadcast(c)
+	editConfig(t, b, "password: 'secret'", "password: 'changed'")
+	c, err = LoadConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.MigrateCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if credentials := c.Credentials("alice"); credentials.Password != "changed" || credentials.Token != "token" {
+		t.Errorf("got %+v, want the changed password and the stored token", credentials)
+	}
+	if stored, _ := c.store.get("alice"); stored.Password != "changed" {
+		t.Errorf("stored password = %q, want the changed one", stored.Password)
+	}
+
+	// The password is kept in the store once it is removed from the file.
+	editConfig(t, b, "    password: 'changed'\n", "")
+	c, err = LoadConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials := c.Credentials("alice"); credentials.Password != "changed" {
+		t.Errorf("password = %q, want the stored one", credentials.Password)
+	}
+}
@@ -21,21 +21,13 @@ const (
 	jpegQuality          = 95
 	numCommentsRequested = 10
 
-	ready                = "Ready"
-	loggingIn            = "Logging in"
-	loginError           = "Login error"
-	challengeRequired    = "Challenge required"
-	challengeError       = "Challenge error"
-	creatingBroadcast    = "Creating broadcast"
-	createBroadcastError = "Create broadcast error"
-	streaming            = "Streaming"
-	encoderRestart       = "Encoder restart"
-	posting              = "Posting"
+	instagramStream = "Instagram"
+	rtmpStream      = "RTMP"
 )
 
 type Stream struct {
 	name          string
-	config        *Config
+	settings      StreamSettings
 	instagram     *instagram.Instagram
 	broadcastID   int
 	uploadURL     string
@@ -48,8 +40,34 @@ type Stream struct {
 	loginRequired bool
 	streaming     bool
 	streamingMux  sync.Mutex
-	status        string
+	stateMux      sync.RWMutex
+	state         *stateMachine
 	broadcast     *Broadcast
+	destination   *Destination
+	proxy         string
+	removed       bool
+	// resuming is set when the next cycle reattaches to a recovered
+	// broadcast instead of creating one.
+	resuming bool
+
+	viewers *viewerSeries
+}
+
+// StreamInfo is a snapshot of the state of a stream.
+type StreamInfo struct {
+	Name                   string          `json:"name"`
+	Type                   string          `json:"type"`
+	Status                 State           `json:"status"`
+	Live                   bool            `json:"live"`
+	ChallengeRequired      bool            `json:"challenge_required"`
+	BroadcastID            int             `json:"broadcast_id,omitempty"`
+	StartTime              *time.Time      `json:"start_time,omitempty"`
+	ViewerCount            int             `json:"viewer_count"`
+	PeakViewerCount        int             `json:"peak_viewer_count"`
+	TotalUniqueViewerCount int             `json:"total_unique_viewer_count"`
+	State                  StateInfo       `json:"state"`
+	Settings               *StreamSettings `json:"settings,omitempty"`
+	Proxy                  string          `json:"proxy,omitempty"`
 }
 
 type broadcastStoppedError struct {
@@ -63,7 +81,7 @@ func (e broadcastStoppedError) Error() string {
 func NewStream(name string, config *Config, broadcast *Broadcast) *Stream {
 	var s = &Stream{
 		name:          name,
-		config:        config,
+		settings:      config.StreamSettings(name),
 		instagram:     nil,
 		broadcastID:   0,
 		uploadURL:     "",
@@ -76,23 +94,210 @@ func NewStream(name string, config *Config, broadcast *Broadcast) *Stream {
 		loginRequired: true,
 		streaming:     false,
 		streamingMux:  sync.Mutex{},
-		status:        ready,
+		stateMux:      sync.RWMutex{},
 		broadcast:     broadcast,
+		destination:   nil,
+		viewers:       newViewerSeries(0),
+	}
+	s.state = newStateMachine(name, s.publishTransition)
+
+	if account := config.Accounts[name]; account != nil && account.proxyURL != nil {
+		s.proxy = instagram.RedactProxy(account.proxyURL)
 	}
 
 	return s
 }
 
+// NewDestinationStream creates a stream that pushes to a plain RTMP
+// destination. It shares the lifecycle of Instagram streams but skips
+// login, broadcast creation and heartbeats.
+func NewDestinationStream(name string, destination *Destination, config *Config, broadcast *Broadcast) *Stream {
+	s := NewStream(name, config, broadcast)
+	s.destination = destination
+	s.loginRequired = false
+	return s
+}
+
+// Settings returns the effective stream-level settings, or nil for plain
+// RTMP destinations which do not use them.
+func (s *Stream) Settings() *StreamSettings {
+	if s.destination != nil {
+		return nil
+	}
+	settings := s.currentSettings()
+	return &settings
+}
+
+func (s *Stream) currentSettings() StreamSettings {
+	s.stateMux.RLock()
+	defer s.stateMux.RUnlock()
+	return s.settings
+}
+
+func (s *Stream) Type() string {
+	if s.destination != nil {
+		return rtmpStream
+	}
+	return instagramStream
+}
+
+// IsStreaming reports whether the stream has been started. It does not
+// block while the stream is being started or stopped.
+func (s *Stream) IsStreaming() bool {
+	s.stateMux.RLock()
+	defer s.stateMux.RUnlock()
+	return s.streaming
+}
+
+func (s *Stream) setStreaming(streaming bool) {
+	s.stateMux.Lock()
+	s.streaming = streaming
+	s.stateMux.Unlock()
+
+	s.broadcast.publishStatus(statusEventLive, s, nil)
+}
+
+func (s *Stream) publishTransition(t Transition) {
+	event := statusEventState
+	if t.To == encoderRestart {
+		event = statusEventEncoderRestart
+		s.broadcast.metrics.encoderRestarts.WithLabelValues(s.name).Inc()
+	}
+	s.broadcast.publishStatus(event, s, &t)
+}
+
+func (s *Stream) setViewerCount(viewerCount int, totalUniqueViewerCount int) {
+	s.viewers.add(ViewerSample{
+		Time:                   time.Now(),
+		ViewerCount:            viewerCount,
+		TotalUniqueViewerCount: totalUniqueViewerCount,
+	})
+	s.broadcast.recordCombinedViewers()
+	s.broadcast.publishStatus(statusEventViewers, s, nil)
+}
+
+// setBroadcast records the broadcast that is being streamed to and resets
+// the viewer counts of the previous one.
+func (s *Stream) setBroadcast(broadcastID int, uploadURL string, startTime time.Time) {
+	s.stateMux.Lock()
+	s.broadcastID = broadcastID
+	s.uploadURL = uploadURL
+	s.startTime = startTime
+	s.viewers.reset()
+	s.stateMux.Unlock()
+
+	s.broadcast.setActiveBroadcast(s.name, &activeBroadcast{
+		BroadcastID: broadcastID,
+		UploadURL:   uploadURL,
+		StartTime:   startTime,
+	})
+}
+
+// setProxy records the proxy of the logged in client, which may come from
+// the token rather than the config.
+func (s *Stream) setProxy(proxy string) {
+	s.stateMux.Lock()
+	defer s.stateMux.Unlock()
+	s.proxy = proxy
+}
+
+// applyConfig updates the settings of the stream from a reloaded config.
+// The proxy is not changed, since it is part of the logged in client.
+func (s *Stream) applyConfig(c *Config) {
+	s.stateMux.Lock()
+	defer s.stateMux.Unlock()
+
+	s.settings = c.StreamSettings(s.name)
+}
+
+// setDestination changes the URL of a destination stream unless it is
+// streaming.
+func (s *Stream) setDestination(destination *Destination) bool {
+	s.streamingMux.Lock()
+	defer s.streamingMux.Unlock()
+
+	if s.IsStreaming() {
+		return false
+	}
+	*s.destination = *destination
+	return true
+}
+
+// remove marks the stream as removed from the config so that it can no
+// longer be started, unless it is streaming.
+func (s *Stream) remove() bool {
+	s.streamingMux.Lock()
+	defer s.streamingMux.Unlock()
+
+	if s.IsStreaming() {
+		return false
+	}
+	s.removed = true
+	return true
+}
+
+func (s *Stream) Info() StreamInfo {
+	state := s.state.info()
+	viewers := s.viewers.stats(false)
+	settings := s.Settings()
+
+	s.stateMux.RLock()
+	defer s.stateMux.RUnlock()
+
+	info := StreamInfo{
+		Name:                   s.name,
+		Type:                   s.Type(),
+		Status:                 state.State,
+		Live:                   s.streaming,
+		ChallengeRequired:      state.State == challengeRequired,
+		ViewerCount:            viewers.ViewerCount,
+		PeakViewerCount:        viewers.PeakViewerCount,
+		TotalUniqueViewerCount: viewers.TotalUniqueViewerCount,
+		State:                  state,
+		Settings:               settings,
+		Proxy:                  s.proxy,
+	}
+
+	if s.streaming {
+		info.BroadcastID = s.broadcastID
+		if !s.startTime.IsZero() {
+			startTime := s.startTime
+			info.StartTime = &startTime
+		}
+	}
+
+	return info
+}
+
 func (s *Stream) Start() error {
 	s.streamingMux.Lock()
 	defer s.streamingMux.Unlock()
+	return s.start()
+}
+
+// start starts the event loop. s.streamingMux must be held.
+func (s *Stream) start() error {
+	if s.IsStreaming() {
+		return fmt.Errorf("stream: %s: already started", s.name)
+	}
+
+	if s.removed {
+		return fmt.Errorf("stream: %s: removed from config", s.name)
+	}
+
+	if !s.broadcast.isStreaming() {
+		// Nothing else is live, so this starts a new combined series.
+		s.broadcast.viewers.reset()
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s.ctx = ctx
 	s.cancel = cancel
 
+	s.state.resetRetries()
+	s.broadcast.relay.Acquire()
 	go s.eventLoop()
-	s.streaming = true
+	s.setStreaming(true)
 	return nil
 }
 
@@ -100,22 +305,29 @@ func (s *Stream) Stop() error {
 	s.streamingMux.Lock()
 	defer s.streamingMux.Unlock()
 
-	s.cancel()
-
-	if s.streaming {
-		s.streaming = false
-		return <-s.done
+	if !s.IsStreaming() {
+		return fmt.Errorf("stream: %s: not started", s.name)
 	}
 
-	return nil
+	s.cancel()
+	err := <-s.done
+	s.broadcast.relay.Release()
+	if s.destination != nil {
+		// Instagram broadcasts are removed by the event loop once they
+		// have ended, and kept if ending them failed.
+		s.broadcast.setActiveBroadcast(s.name, nil)
+	}
+	s.setStreaming(false)
+	s.broadcast.recordCombinedViewers()
+	return err
 }
 
 func (s *Stream) eventLoop() {
 	for {
 		select {
 		case <-s.ctx.Done():
+			s.state.set(ready, "stopped")
 			s.done <- nil
-			s.status = ready
 			return
 		default:
 			s.loopCycle()
@@ -124,26 +336,32 @@ func (s *Stream) eventLoop() {
 }
 
 func (s *Stream) loopCycle() {
+	if s.destination != nil {
+		s.relayCycle()
+		return
+	}
+
 	if s.loginRequired {
-		s.status = loggingIn
+		s.state.set(loggingIn, "")
 
 		if err := s.login(); err != nil {
 			switch err := err.(type) {
 			case *instagram.ChallengeError:
 				log.Warnf("stream: %s: challenge code is required", s.name)
+				s.broadcast.metrics.challenges.WithLabelValues(s.name).Inc()
 				s.apiPath = err.Challenge.APIPath
-				s.status = challengeRequired
+				s.state.set(challengeRequired, err.Error())
 
 				if err := s.respondChallenge(); err != nil {
 					log.Errorf("stream: %s: unable to complete challenge: %v", s.name, err)
-					s.status = challengeError
+					s.state.fail(challengeError, err)
 					s.cooldown()
 					return
 				}
 			default:
 				log.Errorf("stream: %s: unable to login: %v", s.name, err)
+				s.state.fail(loginError, err)
 				s.cooldown()
-				s.status = loginError
 				return
 			}
 		}
@@ -152,19 +370,27 @@ func (s *Stream) loopCycle() {
 		s.loginRequired = false
 	}
 
-	s.status = creatingBroadcast
-	if err := s.createBroadcast(s.config.Notify); err != nil {
-		log.Errorf("stream: %s: unable to create broadcast: %v", s.name, err)
-		switch err.(type) {
-		case *instagram.LoginRequiredError:
-			s.loginRequired = true
-			return
-		default:
-			s.status = createBroadcastError
-			s.cooldown()
-			return
+	if s.resuming {
+		// The broadcast was recovered after a restart and is still live.
+		s.resuming = false
+	} else {
+		s.endPendingBroadcast()
+		s.state.set(creatingBroadcast, "")
+		if err := s.createBroadcast(s.currentSettings().Notify); err != nil {
+			log.Errorf("stream: %s: unable to create broadcast: %v", s.name, err)
+			switch err.(type) {
+			case *instagram.LoginRequiredError:
+				s.state.recordError(err)
+				s.loginRequired = true
+				return
+			default:
+				s.state.fail(createBroadcastError, err)
+				s.cooldown()
+				return
+			}
 		}
 	}
+	s.state.resetRetries()
 
 	g, ctx := errgroup.WithContext(s.ctx)
 
@@ -174,12 +400,16 @@ func (s *Stream) loopCycle() {
 			case <-ctx.Done():
 				return nil
 			default:
-				s.status = streaming
+				s.state.set(streaming, "")
 
 				if err := s.runEncoder(ctx); err != nil {
 					log.Errorf("stream: %s: unable to stream broadcast %d: %v", s.name, s.broadcastID, err)
-					s.status = encoderRestart
-					time.Sleep(encoderRestartDelay)
+					s.state.fail(encoderRestart, err)
+
+					select {
+					case <-ctx.Done():
+					case <-time.After(encoderRestartDelay):
+					}
 				}
 			}
 		}
@@ -192,9 +422,13 @@ func (s *Stream) loopCycle() {
 			select {
 			case <-ctx.Done():
 				return nil
-			case <-time.After(time.Duration(s.config.PollInterval) * time.Second):
-				heartbeat, err := s.heartbeatAndStatus()
+			case <-time.After(time.Duration(s.broadcast.Config().PollInterval) * time.Second):
+				heartbeat, err := s.heartbeatAndStatus(ctx)
+				if ctx.Err() != nil {
+					return nil
+				}
 				if err != nil {
+					s.state.recordError(err)
 					log.Errorf("stream: %s: unable to send heartbeat: %v", s.name, err)
 					switch err.(type) {
 					case *instagram.LoginRequiredError:
@@ -206,8 +440,9 @@ func (s *Stream) loopCycle() {
 					}
 				}
 				log.Debugf("stream: %s: heartbeat: %+v", s.name, heartbeat)
+				s.setViewerCount(int(heartbeat.ViewerCount), heartbeat.TotalUniqueViewerCount)
 
-				if s.config.Logging.Enabled {
+				if s.broadcast.Config().Logging.Enabled {
 					currentTime := time.Now().Unix()
 					if err := s.broadcast.writeViewerLog(currentTime, s.broadcastID, s.name,
 						int(heartbeat.ViewerCount), heartbeat.TotalUniqueViewerCount); err != nil {
@@ -215,8 +450,8 @@ func (s *Stream) loopCycle() {
 					}
 				}
 
-				newLastCommentTS, err := s.getComments(lastCommentTS)
-				if err != nil {
+				newLastCommentTS, err := s.getComments(ctx, lastCommentTS)
+				if err != nil && ctx.Err() == nil {
 					log.Errorf("stream: %s: unable to get comments: %v", s.name, err)
 				}
 				lastCommentTS = newLastCommentTS
@@ -228,7 +463,7 @@ func (s *Stream) loopCycle() {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(time.Duration(s.config.Announcement.MinuteMark) * time.Minute):
+		case <-time.After(time.Duration(s.currentSettings().Announcement.MinuteMark) * time.Minute):
 			err := s.putAnnouncement()
 			if err != nil {
 				log.Errorf("stream: %s: unable to put announcement: %v", s.name, err)
@@ -247,23 +482,61 @@ func (s *Stream) loopCycle() {
 		}
 	}
 
-	s.status = posting
+	s.state.set(posting, "")
 	s.endBroadcastAndPost()
 }
 
+func (s *Stream) relayCycle() {
+	if s.state.current() != encoderRestart {
+		s.setBroadcast(0, s.destination.URL, time.Now())
+	}
+	s.state.set(streaming, "")
+
+	if err := s.runEncoder(s.ctx); err != nil {
+		log.Errorf("stream: %s: unable to stream to destination: %v", s.name, err)
+		s.state.fail(encoderRestart, err)
+
+		select {
+		case <-s.ctx.Done():
+		case <-time.After(encoderRestartDelay):
+		}
+	}
+}
+
+// endBroadcastAndPost ends the broadcast and runs the post-live actions. If
+// the broadcast cannot be ended, it is kept in the recovery state, so that
+// ending it is retried on the next start.
 func (s *Stream) endBroadcastAndPost() {
 	if err := s.endBroadcast(); err != nil {
 		log.Errorf("stream: %s: unable to end broadcast: %v", s.name, err)
 		return
 	}
+	s.postLive()
+	s.broadcast.setActiveBroadcast(s.name, nil)
+}
 
-	if s.config.IGTV.Enabled {
+// endPendingBroadcast ends the previous broadcast of the stream if it could
+// not be ended before.
+func (s *Stream) endPendingBroadcast() {
+	active, ok := s.broadcast.recovery.get(s.name)
+	if !ok {
+		return
+	}
+
+	log.Infof("stream: %s: ending previous broadcast %d", s.name, active.BroadcastID)
+	s.setBroadcast(active.BroadcastID, active.UploadURL, active.StartTime)
+	s.endBroadcastAndPost()
+}
+
+// postLive runs the actions that follow the end of a broadcast.
+func (s *Stream) postLive() {
+	if s.currentSettings().IGTV.Enabled {
 		if err := s.postToIGTV(); err != nil {
 			log.Errorf("stream: %s: unable to post to IGTV: %v", s.name, err)
 		}
 	}
 
-	if s.config.Logging.Enabled {
+	if s.broadcast.Config().Logging.Enabled {
 		if err := s.saveFinalViewerList(); err != nil {
 			log.Errorf("stream: %s: unable to save final viewer list to file: %v", s.name, err)
 		}
@@ -282,14 +555,16 @@ func (s *Stream) cooldown() {
 
 func (s *Stream) login() error {
 	username := s.name
-	token := s.config.Accounts[username].Token
-	password := s.config.Accounts[username].Password
+	credentials := s.broadcast.Config().Credentials(username)
+	token := credentials.Token
+	password := credentials.Password
 
 	if token != "" {
 		// Try to login using an existing token.
 		i, err := s.loginByToken(username, token)
 		if err == nil {
 			s.instagram = i
+			s.setProxy(i.Proxy())
 			return nil
 		}
 
@@ -306,10 +581,11 @@ func (s *Stream) login() error {
 	// the challenge can be responded using the client.
 	s.instagram = i
 
-	// No challenge is required, login is successful.
+	// No challenge is required, login is successful. A token that cannot
+	// be saved only means that the next login uses the password again.
 	if err == nil {
 		if err := s.persistToken(); err != nil {
-			return err
+			log.Warn(err)
 		}
 		return nil
 	}
@@ -320,10 +596,12 @@ func (s *Stream) login() error {
 
 func (s *Stream) loginByToken(username string, token string) (*instagram.Instagram, error) {
 	log.Debugf("stream: %s: logging in by token", s.name)
-	i, err := instagram.ImportFromString(token)
+	i, err := instagram.ImportFromString(token, s.instagramOptions()...)
 	if err != nil {
+		s.broadcast.metrics.loginAttempts.WithLabelValues(s.name, "token", "failure").Inc()
 		return nil, fmt.Errorf("stream: %s: unable to login by token: %v", username, err)
 	}
+	s.broadcast.metrics.loginAttempts.WithLabelValues(s.name, "token", "success").Inc()
 
 	log.Debugf("stream: %s: successfully logged in by token", s.name)
 	return i, nil
@@ -331,15 +609,28 @@ func (s *Stream) loginByToken(username string, token string) (*instagram.Instagr
 
 func (s *Stream) loginByPassword(username string, password string) (*instagram.Instagram, error) {
 	log.Debugf("stream: %s: logging in by password", s.name)
-	i := instagram.New(username, password)
+	i := instagram.New(username, password, s.instagramOptions()...)
 	if err := i.Login(); err != nil {
+		result := "failure"
+		if _, ok := err.(*instagram.ChallengeError); ok {
+			result = "challenge"
+		}
+		s.broadcast.metrics.loginAttempts.WithLabelValues(s.name, "password", result).Inc()
 		return i, err
 	}
+	s.broadcast.metrics.loginAttempts.WithLabelValues(s.name, "password", "success").Inc()
 
 	log.Debugf("stream: %s: successfully logged in by password", s.name)
 	return i, nil
 }
 
+func (s *Stream) instagramOptions() []instagram.Option {
+	opts := []instagram.Option{
+		instagram.WithRequestObserver(s.broadcast.metrics.observeRequest(s.name)),
+	}
+	return append(opts, s.broadcast.Config().InstagramOptions(s.name)...)
+}
+
 func (s *Stream) respondChallenge() error {
 	log.Debugf("stream: %s: processing challenge", s.name)
 	err := s.instagram.Challenge.Process(s.apiPath)
@@ -349,6 +640,8 @@ func (s *Stream) respondChallenge() error {
 
 	log.Debugf("stream: %s: waiting for security code", s.name)
 	select {
+	case <-s.ctx.Done():
+		return fmt.Errorf("stream: %s: stopped while waiting for challenge security code", s.name)
 	case <-time.After(challengeTimeout):
 		return fmt.Errorf("stream: %s: timed out while waiting for challenge security code", s.name)
 	case code := <-s.securityCode:
@@ -362,7 +655,7 @@ func (s *Stream) respondChallenge() error {
 	log.Debugf("stream: %s: successfully responded to challenge", s.name)
 	s.instagram.Account = s.instagram.Challenge.LoggedInUser
 	if err := s.persistToken(); err != nil {
-		return err
+		log.Warn(err)
 	}
 
 	return nil
@@ -374,19 +667,18 @@ func (s *Stream) persistToken() error {
 	if err != nil {
 		return fmt.Errorf("stream: %s: unable to export new token: %v", s.name, err)
 	}
-	s.config.Accounts[s.name].Token = newToken
-
-	if err := s.config.SaveConfig(); err != nil {
-		return fmt.Errorf("stream: %s: unable to update config: %v", s.name, err)
+	if err := s.broadcast.Config().SaveToken(s.name, newToken); err != nil {
+		return fmt.Errorf("stream: %s: unable to persist token: %v", s.name, err)
 	}
 
-	log.Debugf("stream: %s: successfully persisted token in config file", s.name)
+	log.Debugf("stream: %s: successfully persisted token", s.name)
 	return nil
 }
 
 func (s *Stream) createBroadcast(notify bool) error {
 	log.Debugf("stream: %s: creating broadcast", s.name)
-	live, err := s.instagram.Live.Create(s.config.Encoder.Width, s.config.Encoder.Height, s.config.Title)
+	encoder := s.broadcast.Config().Encoder
+	live, err := s.instagram.Live.Create(encoder.Width, encoder.Height, s.currentSettings().Title)
 	if err != nil {
 		return err
 	}
@@ -424,31 +716,48 @@ func (s *Stream) createBroadcast(notify bool) error {
 			s.name, live.BroadcastID, disableRequestToJoin.Status)
 	}
 
-	s.broadcastID = live.BroadcastID
-	s.uploadURL = live.UploadURL
-	s.startTime = time.Now()
+	s.setBroadcast(live.BroadcastID, live.UploadURL, time.Now())
 
 	log.Infof("stream: %s: successfully started broadcast %d", s.name, s.broadcastID)
 	return nil
 }
 
+// runEncoder pushes the shared relay output to the upload URL. The process
+// only remuxes, so one failing output does not affect the others.
 func (s *Stream) runEncoder(ctx context.Context) error {
+	encoder := s.broadcast.Config().Encoder
+
 	var args []string
-	args = append(args, "-i", s.config.InputURL)
-	args = append(args, s.config.Encoder.Args...)
+	args = append(args, "-f", "flv", "-i", "pipe:0")
+	args = append(args, encoder.OutputArgs...)
 	args = append(args, "-f", "flv")
 	args = append(args, s.uploadURL)
 
-	cmd := exec.CommandContext(ctx, s.config.Encoder.Command, args...)
+	cmd := exec.CommandContext(ctx, encoder.Command, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	sub := s.broadcast.relay.Subscribe()
+	defer s.broadcast.relay.Unsubscribe(sub)
+
 	log.Debugf("stream: %s: starting encoder process", s.name)
 	if err := cmd.Start(); err != nil {
 		return err
 	}
 	log.Infof("stream: %s: encoder process started", s.name)
 
+	go func() {
+		if _, err := sub.WriteTo(stdin); err != nil {
+			log.Debugf("stream: %s: encoder input closed: %v", s.name, err)
+		}
+		stdin.Close()
+	}()
+
 	if err := cmd.Wait(); err != nil {
 		if errors.Is(ctx.Err(), context.Canceled) {
 			log.Debugf("stream: %s: encoder process killed by context cancellation", s.name)
@@ -460,9 +769,9 @@ func (s *Stream) runEncoder(ctx context.Context) error {
 	return nil
 }
 
-func (s *Stream) heartbeatAndStatus() (*instagram.LiveHeartbeatAndGetViewerCountResponse, error) {
+func (s *Stream) heartbeatAndStatus(ctx context.Context) (*instagram.LiveHeartbeatAndGetViewerCountResponse, error) {
 	log.Debugf("stream: %s: sending heartbeat and getting viewer count for broadcast %d", s.name, s.broadcastID)
-	heartbeat, err := s.instagram.Live.HeartbeatAndGetViewerCount(s.broadcastID)
+	heartbeat, err := s.instagram.Live.HeartbeatAndGetViewerCountContext(ctx, s.broadcastID)
 	if err != nil {
 		return nil, err
 	}
@@ -479,9 +788,9 @@ func (s *Stream) heartbeatAndStatus() (*instagram.LiveHeartbeatAndGetViewerCount
 	return heartbeat, nil
 }
 
-func (s *Stream) getComments(lastCommentTS int) (int, error) {
+func (s *Stream) getComments(ctx context.Context, lastCommentTS int) (int, error) {
 	log.Debugf("stream: %s: getting comments from broadcast %d", s.name, s.broadcastID)
-	comments, err := s.instagram.Live.GetComment(s.broadcastID, numCommentsRequested, lastCommentTS)
+	comments, err := s.instagram.Live.GetCommentContext(ctx, s.broadcastID, numCommentsRequested, lastCommentTS)
 	if err != nil {
 		return lastCommentTS, err
 	}
@@ -520,8 +829,9 @@ func (s *Stream) endBroadcast() error {
 }
 
 func (s *Stream) postToIGTV() error {
+	settings := s.currentSettings()
 	duration := time.Now().Sub(s.startTime)
-	minDuration := time.Duration(s.config.IGTV.MinDuration) * time.Minute
+	minDuration := time.Duration(settings.IGTV.MinDuration) * time.Minute
 	if duration < minDuration {
 		return fmt.Errorf("stream: %s: broadcast duration is too short, will not post to IGTV", s.name)
 	}
@@ -551,9 +861,9 @@ func (s *Stream) postToIGTV() error {
 	igtv, err := s.instagram.Live.AddPostLiveToIGTV(
 		s.broadcastID,
 		uploadID,
-		s.config.Title,
-		s.config.IGTV.Description,
-		s.config.IGTV.ShareToFeed,
+		settings.Title,
+		settings.IGTV.Description,
+		settings.IGTV.ShareToFeed,
 	)
 	if err != nil {
 		return err
@@ -590,7 +900,7 @@ func (s *Stream) saveFinalViewerList() error {
 
 func (s *Stream) putAnnouncement() error {
 	log.Debugf("stream: %s: putting announcement for broadcast %d", s.name, s.broadcastID)
-	comment, err := s.instagram.Live.Comment(s.broadcastID, s.config.Announcement.Message)
+	comment, err := s.instagram.Live.Comment(s.broadcastID, s.currentSettings().Announcement.Message)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,292 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testTimeout = 10 * time.Second
+
+// newTestBroadcast loads a config with a single account that talks to ig,
+// and an encoder that discards its input until it is killed.
+func newTestBroadcast(t *testing.T, ig *fakeig.Server) *Broadcast {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "broadcastd-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	encoder := filepath.Join(dir, "encoder.sh")
+	if err := ioutil.WriteFile(encoder, []byte("#!/bin/sh\nexec cat > /dev/null\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf(`input_url: 'rtmp://127.0.0.1/live/test'
+accounts:
+  alice:
+    password: 'secret'
+encoder:
+  command: '%s'
+poll_interval: 1
+announcement:
+  minute_mark: 60
+instagram:
+  base_url: '%s'
+  retry:
+    initial_backoff: 1
+    max_backoff: 10
+`, encoder, ig.URL)
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newBroadcast(c)
+}
+
+// startCycle runs a single cycle of the event loop of s in the background.
+// The returned channel is closed when the cycle returns.
+func startCycle(t *testing.T, s *Stream) chan struct{} {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx = ctx
+	s.cancel = cancel
+	t.Cleanup(cancel)
+
+	done := make(chan struct{})
+	go func() {
+		s.loopCycle()
+		close(done)
+	}()
+	return done
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for the cycle to return")
+	}
+}
+
+// activeBroadcastID waits for the stream to go live on ig and returns the
+// ID of its broadcast.
+func activeBroadcastID(t *testing.T, ig *fakeig.Server, s *Stream) int {
+	t.Helper()
+
+	id := 0
+	waitFor(t, "an active broadcast", func() bool {
+		for _, b := range ig.Broadcasts() {
+			if b.Status == "active" {
+				id = b.ID
+			}
+		}
+		return id != 0 && s.state.current() == streaming
+	})
+	return id
+}
+
+func hasTransition(s *Stream, from State, to State) bool {
+	for _, t := range s.state.info().History {
+		if t.From == from && t.To == to {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLoopCycleBroadcastsUntilStopped(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	s, _ := b.stream("alice")
+
+	done := startCycle(t, s)
+	id := activeBroadcastID(t, ig, s)
+	if err := ig.StopBroadcast(id); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+
+	if calls := ig.Calls(fakeig.RouteLiveEnd); calls != 1 {
+		t.Errorf("end_broadcast called %d times, want 1", calls)
+	}
+	if s.loginRequired {
+		t.Error("login is required after a successful cycle")
+	}
+	if b.Config().Credentials("alice").Token == "" {
+		t.Error("token was not saved after logging in")
+	}
+	for _, transition := range [][2]State{
+		{ready, loggingIn},
+		{loggingIn, creatingBroadcast},
+		{creatingBroadcast, streaming},
+		{streaming, posting},
+	} {
+		if !hasTransition(s, transition[0], transition[1]) {
+			t.Errorf("missing transition from %s to %s", transition[0], transition[1])
+		}
+	}
+}
+
+func TestLoopCycleAnswersChallenge(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+	ig.Enqueue(fakeig.RouteLogin, fakeig.ChallengeRequired("/challenge/1/abc/"))
+
+	b := newTestBroadcast(t, ig)
+	s, _ := b.stream("alice")
+
+	done := startCycle(t, s)
+	waitFor(t, "the challenge", func() bool {
+		return s.state.current() == challengeRequired
+	})
+	// The code is dropped until the stream waits for it.
+	waitFor(t, "the security code to be accepted", func() bool {
+		s.PutSecurityCode(ig.SecurityCode)
+		return s.state.current() != challengeRequired
+	})
+
+	id := activeBroadcastID(t, ig, s)
+	if err := ig.StopBroadcast(id); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, done)
+
+	if !hasTransition(s, challengeRequired, creatingBroadcast) {
+		t.Error("broadcast was not created after the challenge")
+	}
+	if b.Config().Credentials("alice").Token == "" {
+		t.Error("token was not saved after the challenge")
+	}
+}
+
+func TestLoopCycleLogsInAgainWhenLoginRequired(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	s, _ := b.stream("alice")
+
+	done := startCycle(t, s)
+	activeBroadcastID(t, ig, s)
+	ig.Enqueue(fakeig.RouteLiveHeartbeat, fakeig.LoginRequired())
+	waitDone(t, done)
+
+	if !s.loginRequired {
+		t.Fatal("login is not required after login_required")
+	}
+	if calls := ig.Calls(fakeig.RouteLiveEnd); calls != 0 {
+		t.Errorf("end_broadcast called %d times, want 0", calls)
+	}
+
+	// The next cycle logs in with the saved token and starts over.
+	logins := ig.Calls(fakeig.RouteLogin)
+	done = startCycle(t, s)
+	waitFor(t, "a second broadcast", func() bool {
+		return len(ig.Broadcasts()) == 2 && s.state.current() == streaming
+	})
+	s.cancel()
+	waitDone(t, done)
+
+	if s.loginRequired {
+		t.Error("login is still required after logging in again")
+	}
+	if calls := ig.Calls(fakeig.RouteLogin); calls != logins {
+		t.Errorf("logged in by password %d more times, want the saved token to be used", calls-logins)
+	}
+}
+
+func TestLoopCycleSavesTokenOfEnvironmentAccount(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	editConfig(t, b, "accounts:\n  alice:\n    password: 'secret'\n", "")
+	os.Setenv("BROADCASTD_ACCOUNTS_ALICE_PASSWORD", "secret")
+	defer os.Unsetenv("BROADCASTD_ACCOUNTS_ALICE_PASSWORD")
+	c, err := LoadConfig(b.Config().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = newBroadcast(c)
+	s, _ := b.stream("alice")
+
+	done := startCycle(t, s)
+	activeBroadcastID(t, ig, s)
+	s.cancel()
+	waitDone(t, done)
+
+	if s.state.current() == loginError || hasTransition(s, loggingIn, loginError) {
+		t.Fatal("login failed because the token could not be saved")
+	}
+	c, err = LoadConfig(c.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Credentials("alice").Token == "" {
+		t.Error("token was not saved to the config file")
+	}
+}
+
+func TestLoopCycleRetriesEndingBroadcast(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	s, _ := b.stream("alice")
+
+	done := startCycle(t, s)
+	id := activeBroadcastID(t, ig, s)
+	ig.Enqueue(fakeig.RouteLiveEnd, fakeig.Fail(400, "unable to end"))
+	s.cancel()
+	waitDone(t, done)
+
+	if active, ok := b.recovery.get("alice"); !ok || active.BroadcastID != id {
+		t.Fatalf("broadcast %d is not kept after end_broadcast failed", id)
+	}
+
+	// The next cycle ends the broadcast before creating another one.
+	done = startCycle(t, s)
+	waitFor(t, "a second broadcast", func() bool {
+		return len(ig.Broadcasts()) == 2 && s.state.current() == streaming
+	})
+	if calls := ig.Calls(fakeig.RouteLiveEnd); calls != 2 {
+		t.Errorf("end_broadcast called %d times, want 2", calls)
+	}
+	if active, ok := b.recovery.get("alice"); !ok || active.BroadcastID == id {
+		t.Errorf("broadcast %d is still kept after it ended", id)
+	}
+	s.cancel()
+	waitDone(t, done)
+
+	if _, ok := b.recovery.get("alice"); ok {
+		t.Error("broadcast is kept after it ended")
+	}
+}
@@ -0,0 +1,260 @@
+package broadcast
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists every problem found in a config file, so that
+// they can all be fixed at once.
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("config: %s has %d problem(s):\n  - %s",
+		e.Path, len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// validate checks the config after the defaults have been applied and
+// returns every problem it finds.
+func (c *Config) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.InputURL == "" && !c.Ingest.Enabled {
+		add("input_url: required unless ingest is enabled")
+	}
+
+	if c.Ingest.Enabled {
+		if c.Ingest.StreamKey == "" {
+			add("ingest.stream_key: required when ingest is enabled")
+		}
+		if !validPort(c.Ingest.BindPort) {
+			add("ingest.bind_port: %d is not a valid port", c.Ingest.BindPort)
+		}
+	}
+
+	if c.AutoLive.StartDelay < 0 {
+		add("auto_live.start_delay: must not be negative")
+	}
+	if c.AutoLive.StopDelay < 0 {
+		add("auto_live.stop_delay: must not be negative")
+	}
+	if c.AutoLive.ProbeInterval < 0 {
+		add("auto_live.probe_interval: must be positive")
+	}
+
+	if len(c.Accounts) == 0 && len(c.Destinations) == 0 {
+		add("accounts: at least one account or destination is required")
+	}
+
+	for _, name := range sortedKeys(c.Accounts) {
+		account := c.Accounts[name]
+		if _, ok := c.Destinations[name]; ok {
+			add("destinations.%s: has the same name as an account", name)
+		}
+		// A password that cannot be resolved has already been reported.
+		if credentials := c.Credentials(name); credentials.Password == "" && credentials.Token == "" && !account.unresolved {
+			add("accounts.%s: has neither a password nor a token", name)
+		}
+		if o := account.Announcement; o != nil && o.MinuteMark != nil && *o.MinuteMark < 0 {
+			add("accounts.%s.announcement.minute_mark: must not be negative", name)
+		}
+	}
+
+	for _, name := range sortedKeys(c.Destinations) {
+		destination := c.Destinations[name]
+		if destination == nil || destination.URL == "" {
+			add("destinations.%s.url: required", name)
+			continue
+		}
+		if u, err := url.Parse(destination.URL); err != nil || (u.Scheme != "rtmp" && u.Scheme != "rtmps") {
+			add("destinations.%s.url: must be an rtmp:// or rtmps:// URL", name)
+		}
+	}
+
+	if !validPort(c.BindPort) {
+		add("bind_port: %d is not a valid port", c.BindPort)
+	}
+
+	if c.Auth.Enabled {
+		if len(c.Auth.Users) == 0 && len(c.Auth.APITokens) == 0 {
+			add("auth: enabled but has no users or api_tokens")
+		}
+		for _, username := range sortedKeys(c.Auth.Users) {
+			if _, err := bcrypt.Cost([]byte(c.Auth.Users[username])); err != nil {
+				add("auth.users.%s: not a valid bcrypt hash: %v", username, err)
+			}
+		}
+	}
+	if c.Auth.SessionTTL < 0 {
+		add("auth.session_ttl: must be positive")
+	}
+
+	if c.Encoder.Width < 0 || c.Encoder.Height < 0 {
+		add("encoder: width and height must be positive")
+	}
+
+	if c.PollInterval < 0 {
+		add("poll_interval: must be positive")
+	}
+	if c.ShutdownTimeout < 0 {
+		add("shutdown_timeout: must be positive")
+	}
+
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		add("log_level: %v", err)
+	}
+
+	if c.Announcement.MinuteMark < 0 {
+		add("announcement.minute_mark: must not be negative")
+	}
+
+	if c.Instagram.BaseURL != "" {
+		if u, err := url.Parse(c.Instagram.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			add("instagram.base_url: must be an http:// or https:// URL")
+		}
+	}
+
+	retry := c.Instagram.Retry
+	if retry.MaxAttempts < 1 {
+		add("instagram.retry.max_attempts: must be at least 1")
+	}
+	if retry.InitialBackoff < 0 || retry.MaxBackoff < 0 {
+		add("instagram.retry: backoffs must be positive")
+	} else if retry.InitialBackoff > retry.MaxBackoff {
+		add("instagram.retry.initial_backoff: must not exceed max_backoff")
+	}
+	if retry.Multiplier < 1 {
+		add("instagram.retry.multiplier: must be at least 1")
+	}
+	if retry.Jitter < 0 || retry.Jitter > 1 {
+		add("instagram.retry.jitter: must be between 0 and 1")
+	}
+
+	limits := c.Instagram.RateLimits
+	if limits.Default != nil && (limits.Default.PerMinute < 0 || limits.Default.Burst < 0) {
+		add("instagram.rate_limits.default: per_minute and burst must not be negative")
+	}
+	for _, endpoint := range sortedKeys(limits.Endpoints) {
+		if limit := limits.Endpoints[endpoint]; limit.PerMinute < 0 || limit.Burst < 0 {
+			add("instagram.rate_limits.endpoints.%s: per_minute and burst must not be negative", endpoint)
+		}
+	}
+
+	if c.Recovery.Action != recoveryResume && c.Recovery.Action != recoveryEnd {
+		add("recovery.action: must be %s or %s", recoveryResume, recoveryEnd)
+	}
+
+	return problems
+}
+
+// LoadHostConfig loads a config file like LoadConfig, and also checks that
+// it can be used to broadcast from this host: that the encoder and probe
+// commands exist and that the log and state directories are writable.
+// Reloads and the commands that only manage accounts skip these checks,
+// since they may run elsewhere.
+func LoadHostConfig(configPath string) (*Config, error) {
+	c, err := LoadConfig(configPath)
+	if c == nil {
+		return nil, err
+	}
+
+	problems := c.checkHost()
+	if len(problems) == 0 {
+		return c, err
+	}
+	if verr, ok := err.(*ValidationError); ok {
+		verr.Problems = append(verr.Problems, problems...)
+		return c, verr
+	}
+	return c, &ValidationError{Path: configPath, Problems: problems}
+}
+
+// checkHost returns the problems of the config on this host.
+func (c *Config) checkHost() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.AutoLive.Enabled && !c.Ingest.Enabled {
+		if _, err := exec.LookPath(c.AutoLive.ProbeCommand); err != nil {
+			add("auto_live.probe_command: %v", err)
+		}
+	}
+
+	if _, err := exec.LookPath(c.Encoder.Command); err != nil {
+		add("encoder.command: %v", err)
+	}
+
+	if c.Logging.Enabled {
+		if err := checkWritable(c.Logging.LogDirectory); err != nil {
+			add("logging.log_directory: %v", err)
+		}
+	}
+
+	if c.Recovery.StateFile != "" {
+		if err := checkWritable(filepath.Dir(c.Recovery.StateFile)); err != nil {
+			add("recovery.state_file: %v", err)
+		}
+	}
+
+	return problems
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// checkWritable checks that files can be created in dir, or in its nearest
+// existing parent if it does not exist yet.
+func checkWritable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+
+	// The directory is not written to, so that validating has no side
+	// effects.
+	if err := accessWritable(dir); err != nil {
+		return fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map with string keys in order.
+func sortedKeys(m interface{}) []string {
+	v := reflect.ValueOf(m)
+	keys := make([]string, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
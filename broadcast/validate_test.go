@@ -0,0 +1,69 @@
+package broadcast
+
+import (
+	"encoding/base64"
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// snapshotDir returns the modification time of every file and directory
+// under dir.
+func snapshotDir(t *testing.T, dir string) map[string]time.Time {
+	t.Helper()
+
+	files := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files[path] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestLoadHostConfigHasNoSideEffects(t *testing.T) {
+	ig := fakeig.NewServer()
+	defer ig.Close()
+
+	b := newTestBroadcast(t, ig)
+	dir := filepath.Dir(b.Config().path)
+	keyFile := filepath.Join(dir, "credentials.key")
+	if err := ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(testStoreKey(1))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	editConfig(t, b, "poll_interval: 1", `poll_interval: 1
+credential_store:
+  path: '`+filepath.Join(dir, "credentials.enc")+`'
+  key_file: '`+keyFile+`'
+logging:
+  enabled: true
+  log_directory: '`+filepath.Join(dir, "logs")+`'
+recovery:
+  state_file: '`+filepath.Join(dir, "state", "state.json")+`'`)
+
+	before := snapshotDir(t, dir)
+	c, err := LoadHostConfig(b.Config().path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := snapshotDir(t, dir); !reflect.DeepEqual(before, after) {
+		t.Errorf("loading the config changed %s: before %v, after %v", dir, before, after)
+	}
+
+	// The credentials in the file are used without migrating them.
+	if password := c.Credentials("alice").Password; password != "secret" {
+		t.Errorf("password = %q, want the one in the config file", password)
+	}
+}
@@ -0,0 +1,123 @@
+package broadcast
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	maxViewerSamples = 4096
+)
+
+type ViewerSample struct {
+	Time                   time.Time `json:"time"`
+	ViewerCount            int       `json:"viewer_count"`
+	TotalUniqueViewerCount int       `json:"total_unique_viewer_count"`
+}
+
+// ViewerStats summarises the viewers of a broadcast.
+type ViewerStats struct {
+	ViewerCount            int            `json:"viewer_count"`
+	PeakViewerCount        int            `json:"peak_viewer_count"`
+	TotalUniqueViewerCount int            `json:"total_unique_viewer_count"`
+	Samples                []ViewerSample `json:"samples,omitempty"`
+}
+
+// viewerSeries keeps the viewer counts of a broadcast in memory. Samples
+// closer together than the interval replace each other, and the resolution
+// is halved whenever the series grows past maxViewerSamples, so that long
+// broadcasts stay bounded while still covering their full duration.
+type viewerSeries struct {
+	mux      sync.RWMutex
+	interval time.Duration
+	current  ViewerSample
+	peak     int
+	samples  []ViewerSample
+}
+
+func newViewerSeries(interval time.Duration) *viewerSeries {
+	return &viewerSeries{
+		interval: interval,
+	}
+}
+
+func (v *viewerSeries) add(sample ViewerSample) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	v.current = sample
+	if sample.ViewerCount > v.peak {
+		v.peak = sample.ViewerCount
+	}
+
+	if n := len(v.samples); n > 0 && sample.Time.Sub(v.samples[n-1].Time) < v.interval {
+		v.samples[n-1] = sample
+		return
+	}
+
+	v.samples = append(v.samples, sample)
+	if len(v.samples) > maxViewerSamples {
+		halved := v.samples[:0]
+		for i := 0; i < len(v.samples); i += 2 {
+			halved = append(halved, v.samples[i])
+		}
+		v.samples = halved
+	}
+}
+
+func (v *viewerSeries) reset() {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	v.current = ViewerSample{}
+	v.peak = 0
+	v.samples = nil
+}
+
+// stats returns the current and peak counts, along with a copy of the
+// samples if withSamples is set.
+func (v *viewerSeries) stats(withSamples bool) ViewerStats {
+	v.mux.RLock()
+	defer v.mux.RUnlock()
+
+	stats := ViewerStats{
+		ViewerCount:            v.current.ViewerCount,
+		PeakViewerCount:        v.peak,
+		TotalUniqueViewerCount: v.current.TotalUniqueViewerCount,
+	}
+	if withSamples {
+		stats.Samples = make([]ViewerSample, len(v.samples))
+		copy(stats.Samples, v.samples)
+	}
+	return stats
+}
+
+// recordCombinedViewers adds the sum of the viewers of every live stream to
+// the combined series.
+func (b *Broadcast) recordCombinedViewers() {
+	combined := ViewerSample{
+		Time: time.Now(),
+	}
+	for _, stream := range b.streamList() {
+		if !stream.IsStreaming() {
+			continue
+		}
+		stats := stream.viewers.stats(false)
+		combined.ViewerCount += stats.ViewerCount
+		combined.TotalUniqueViewerCount += stats.TotalUniqueViewerCount
+	}
+	b.viewers.add(combined)
+}
+
+// ViewerStats returns the combined viewer statistics across all streams,
+// along with those of every stream.
+func (b *Broadcast) ViewerStats() (ViewerStats, map[string]ViewerStats) {
+	streams := make(map[string]ViewerStats)
+	for _, stream := range b.streamList() {
+		if stream.Type() != instagramStream {
+			continue
+		}
+		streams[stream.name] = stream.viewers.stats(true)
+	}
+	return b.viewers.stats(true), streams
+}
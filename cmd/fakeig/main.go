@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sbekti/broadcastd/instagram/fakeig"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+func main() {
+	var bindIP string
+	var bindPort int
+	flag.StringVar(&bindIP, "ip", "127.0.0.1", "IP address to bind to")
+	flag.IntVar(&bindPort, "port", 8080, "port number to bind to")
+	flag.Parse()
+
+	addr := fmt.Sprintf("%s:%d", bindIP, bindPort)
+
+	s := fakeig.New()
+	s.URL = "http://" + addr
+
+	log.Infof("fakeig: listening on %s, security code is %s", s.URL, s.SecurityCode)
+	if err := http.ListenAndServe(addr, s); err != nil {
+		log.Fatal(err)
+	}
+}
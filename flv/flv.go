@@ -0,0 +1,200 @@
+// Package flv implements the subset of the FLV container format needed to
+// relay live streams: reading and writing tags, and fanning a stream out to
+// subscribers that may join at any point.
+package flv
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	HeaderSize    = 9
+	TagHeaderSize = 11
+	TagSizeSize   = 4
+	MaxTagSize    = 16 * 1024 * 1024
+
+	TagAudio  = 8
+	TagVideo  = 9
+	TagScript = 18
+
+	hasAudio = 0x04
+	hasVideo = 0x01
+
+	SoundFormatMP3   = 2
+	SoundFormatAAC   = 10
+	SoundFormatSpeex = 11
+
+	VideoCodecH263 = 2
+	VideoCodecVP6  = 4
+	VideoCodecAVC  = 7
+	VideoCodecHEVC = 12
+
+	videoKeyframe  = 1
+	sequenceHeader = 0
+)
+
+// NewHeader returns an FLV file header followed by the first (zero)
+// previous tag size.
+func NewHeader(audio bool, video bool) []byte {
+	var flags byte
+	if audio {
+		flags |= hasAudio
+	}
+	if video {
+		flags |= hasVideo
+	}
+	return []byte{'F', 'L', 'V', 1, flags, 0, 0, 0, HeaderSize, 0, 0, 0, 0}
+}
+
+// NewTag returns a complete FLV tag including its trailing previous tag
+// size.
+func NewTag(tagType byte, ts uint32, data []byte) []byte {
+	size := len(data)
+	tag := make([]byte, TagHeaderSize+size+TagSizeSize)
+	tag[0] = tagType
+	tag[1] = byte(size >> 16)
+	tag[2] = byte(size >> 8)
+	tag[3] = byte(size)
+	tag[4] = byte(ts >> 16)
+	tag[5] = byte(ts >> 8)
+	tag[6] = byte(ts)
+	tag[7] = byte(ts >> 24)
+	copy(tag[TagHeaderSize:], data)
+
+	total := uint32(TagHeaderSize + size)
+	tail := tag[TagHeaderSize+size:]
+	tail[0] = byte(total >> 24)
+	tail[1] = byte(total >> 16)
+	tail[2] = byte(total >> 8)
+	tail[3] = byte(total)
+	return tag
+}
+
+// ReadHeader reads the FLV file header and the first previous tag size.
+func ReadHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, HeaderSize+TagSizeSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:3]) != "FLV" {
+		return nil, fmt.Errorf("flv: input is not an FLV stream")
+	}
+	return header, nil
+}
+
+// ReadTag reads a complete FLV tag including its trailing previous tag size.
+func ReadTag(r io.Reader) ([]byte, error) {
+	header := make([]byte, TagHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+	if size > MaxTagSize {
+		return nil, fmt.Errorf("flv: tag of %d bytes is too large", size)
+	}
+
+	tag := make([]byte, TagHeaderSize+size+TagSizeSize)
+	copy(tag, header)
+	if _, err := io.ReadFull(r, tag[TagHeaderSize:]); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func HasVideo(header []byte) bool {
+	return header[4]&hasVideo != 0
+}
+
+func Timestamp(tag []byte) uint32 {
+	return uint32(tag[7])<<24 | uint32(tag[4])<<16 | uint32(tag[5])<<8 | uint32(tag[6])
+}
+
+// WithTimestamp returns tag with its timestamp replaced. The tag is copied
+// if the timestamp changes, since tags are shared between subscribers.
+func WithTimestamp(tag []byte, ts uint32) []byte {
+	if Timestamp(tag) == ts {
+		return tag
+	}
+
+	res := make([]byte, len(tag))
+	copy(res, tag)
+	res[4] = byte(ts >> 16)
+	res[5] = byte(ts >> 8)
+	res[6] = byte(ts)
+	res[7] = byte(ts >> 24)
+	return res
+}
+
+func Data(tag []byte) []byte {
+	return tag[TagHeaderSize : len(tag)-TagSizeSize]
+}
+
+// IsConfig reports whether tag carries metadata or a codec sequence header
+// that a decoder needs before any media.
+func IsConfig(tag []byte) bool {
+	return tag[0] == TagScript || IsVideoConfig(tag) || IsAudioConfig(tag)
+}
+
+func IsVideoConfig(tag []byte) bool {
+	data := Data(tag)
+	if tag[0] != TagVideo || len(data) < 2 {
+		return false
+	}
+	codec := data[0] & 0x0f
+	return (codec == VideoCodecAVC || codec == VideoCodecHEVC) && data[1] == sequenceHeader
+}
+
+func IsAudioConfig(tag []byte) bool {
+	data := Data(tag)
+	if tag[0] != TagAudio || len(data) < 2 {
+		return false
+	}
+	return data[0]>>4 == SoundFormatAAC && data[1] == sequenceHeader
+}
+
+func IsVideoKeyframe(tag []byte) bool {
+	data := Data(tag)
+	return tag[0] == TagVideo && len(data) > 0 && data[0]>>4 == videoKeyframe && !IsVideoConfig(tag)
+}
+
+// VideoCodecName returns a human readable name of the codec in an FLV video
+// tag body.
+func VideoCodecName(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	switch data[0] & 0x0f {
+	case VideoCodecH263:
+		return "H.263"
+	case VideoCodecVP6:
+		return "VP6"
+	case VideoCodecAVC:
+		return "H.264"
+	case VideoCodecHEVC:
+		return "HEVC"
+	default:
+		return fmt.Sprintf("unknown (%d)", data[0]&0x0f)
+	}
+}
+
+// AudioCodecName returns a human readable name of the codec in an FLV audio
+// tag body.
+func AudioCodecName(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+
+	switch data[0] >> 4 {
+	case SoundFormatMP3:
+		return "MP3"
+	case SoundFormatAAC:
+		return "AAC"
+	case SoundFormatSpeex:
+		return "Speex"
+	default:
+		return fmt.Sprintf("unknown (%d)", data[0]>>4)
+	}
+}
@@ -0,0 +1,152 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadHeader(t *testing.T) {
+	header := NewHeader(true, true)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		wantErr bool
+	}{
+		{"valid", header, false},
+		{"not FLV", append([]byte("FLX"), header[3:]...), true},
+		{"truncated", header[:5], true},
+		{"empty", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadHeader(bytes.NewReader(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("read a header, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.input) {
+				t.Fatalf("header = %x, want %x", got, tt.input)
+			}
+		})
+	}
+}
+
+func TestReadTag(t *testing.T) {
+	tag := NewTag(TagVideo, 0x01020304, []byte{0x17, 1, 2, 3})
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    [][]byte
+		wantErr error
+	}{
+		{"single tag", tag, [][]byte{tag}, io.EOF},
+		{"two tags", append(append([]byte{}, tag...), tag...), [][]byte{tag, tag}, io.EOF},
+		{"empty tag", NewTag(TagScript, 0, nil), [][]byte{NewTag(TagScript, 0, nil)}, io.EOF},
+		{"truncated header", tag[:5], nil, io.ErrUnexpectedEOF},
+		{"truncated data", tag[:TagHeaderSize+2], nil, io.ErrUnexpectedEOF},
+		{"missing previous tag size", tag[:len(tag)-1], nil, io.ErrUnexpectedEOF},
+		{"size beyond input", []byte{TagVideo, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 1}, nil, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bytes.NewReader(tt.input)
+			for i, want := range tt.want {
+				got, err := ReadTag(r)
+				if err != nil {
+					t.Fatalf("tag %d: %v", i, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("tag %d = %x, want %x", i, got, want)
+				}
+			}
+			if _, err := ReadTag(r); err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	for _, ts := range []uint32{0, 1, 0xffffff, 0x01000000, 0xffffffff} {
+		tag := NewTag(TagAudio, ts, []byte{0xaf, 1})
+		if got := Timestamp(tag); got != ts {
+			t.Errorf("Timestamp(NewTag(%#x)) = %#x", ts, got)
+		}
+
+		rebased := WithTimestamp(tag, ts/2)
+		if got := Timestamp(rebased); got != ts/2 {
+			t.Errorf("Timestamp(WithTimestamp(%#x)) = %#x", ts/2, got)
+		}
+		if ts != 0 && Timestamp(tag) != ts {
+			t.Errorf("WithTimestamp modified the original tag")
+		}
+		if !bytes.Equal(Data(rebased), []byte{0xaf, 1}) {
+			t.Errorf("WithTimestamp changed the tag data")
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      []byte
+		config   bool
+		keyframe bool
+	}{
+		{"metadata", NewTag(TagScript, 0, []byte{2, 0, 10}), true, false},
+		{"AVC sequence header", NewTag(TagVideo, 0, []byte{0x17, 0}), true, false},
+		{"HEVC sequence header", NewTag(TagVideo, 0, []byte{0x1c, 0}), true, false},
+		{"AVC keyframe", NewTag(TagVideo, 0, []byte{0x17, 1}), false, true},
+		{"AVC inter frame", NewTag(TagVideo, 0, []byte{0x27, 1}), false, false},
+		{"VP6 keyframe", NewTag(TagVideo, 0, []byte{0x14}), false, true},
+		{"AAC sequence header", NewTag(TagAudio, 0, []byte{0xaf, 0}), true, false},
+		{"AAC frame", NewTag(TagAudio, 0, []byte{0xaf, 1}), false, false},
+		{"MP3 frame", NewTag(TagAudio, 0, []byte{0x2f, 0}), false, false},
+		{"empty video", NewTag(TagVideo, 0, nil), false, false},
+		{"empty audio", NewTag(TagAudio, 0, nil), false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsConfig(tt.tag); got != tt.config {
+				t.Errorf("IsConfig = %t, want %t", got, tt.config)
+			}
+			if got := IsVideoKeyframe(tt.tag); got != tt.keyframe {
+				t.Errorf("IsVideoKeyframe = %t, want %t", got, tt.keyframe)
+			}
+		})
+	}
+}
+
+func TestCodecName(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"H.264", VideoCodecName([]byte{0x17}), "H.264"},
+		{"HEVC", VideoCodecName([]byte{0x1c}), "HEVC"},
+		{"unknown video", VideoCodecName([]byte{0x1f}), "unknown (15)"},
+		{"no video data", VideoCodecName(nil), ""},
+		{"AAC", AudioCodecName([]byte{0xaf}), "AAC"},
+		{"MP3", AudioCodecName([]byte{0x2f}), "MP3"},
+		{"unknown audio", AudioCodecName([]byte{0x0f}), "unknown (0)"},
+		{"no audio data", AudioCodecName(nil), ""},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package flv
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sync"
+)
+
+const (
+	subscriptionBufSize = 2048
+)
+
+// Hub fans a live FLV stream out to any number of subscriptions. Each
+// subscription is fed independently: one that falls behind is dropped
+// without affecting the others. Subscriptions may join at any point and
+// receive the header and codec configuration first, then media starting
+// from the next keyframe with timestamps rebased to zero.
+type Hub struct {
+	mux           sync.Mutex
+	header        []byte
+	metadata      []byte
+	videoConfig   []byte
+	audioConfig   []byte
+	subscriptions map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	tags         chan []byte
+	primed       bool
+	waitKeyframe bool
+	baseTS       uint32
+	closed       bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Subscribe() *Subscription {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	sub := &Subscription{
+		tags: make(chan []byte, subscriptionBufSize),
+	}
+	h.subscriptions[sub] = struct{}{}
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.closeSubscription(sub)
+}
+
+// WriteTo copies the subscribed FLV stream to w until the subscription is
+// closed by the hub or writing fails.
+func (sub *Subscription) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for tag := range sub.tags {
+		written, err := w.Write(tag)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// SetHeader starts a new stream. Subscriptions are primed with the header
+// on the next published tag.
+func (h *Hub) SetHeader(header []byte) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.header = header
+}
+
+// ReadFrom reads an FLV stream from r and publishes it until r fails.
+func (h *Hub) ReadFrom(r io.Reader) (int64, error) {
+	header, err := ReadHeader(r)
+	if err != nil {
+		return 0, err
+	}
+	h.SetHeader(header)
+
+	n := int64(len(header))
+	for {
+		tag, err := ReadTag(r)
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(tag))
+		h.Publish(tag)
+	}
+}
+
+func (h *Hub) Publish(tag []byte) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	switch {
+	case tag[0] == TagScript:
+		h.metadata = tag
+	case IsVideoConfig(tag):
+		h.videoConfig = tag
+	case IsAudioConfig(tag):
+		h.audioConfig = tag
+	}
+
+	for sub := range h.subscriptions {
+		h.deliver(sub, tag)
+	}
+}
+
+// Reset ends the current stream and closes every subscription, since
+// downstream muxers cannot follow a new FLV header mid-stream.
+func (h *Hub) Reset() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	h.header = nil
+	h.metadata = nil
+	h.videoConfig = nil
+	h.audioConfig = nil
+
+	for sub := range h.subscriptions {
+		h.closeSubscription(sub)
+	}
+}
+
+func (h *Hub) deliver(sub *Subscription, tag []byte) {
+	if !sub.primed {
+		if h.header == nil {
+			return
+		}
+
+		h.send(sub, h.header)
+		for _, init := range [][]byte{h.metadata, h.videoConfig, h.audioConfig} {
+			if init != nil {
+				h.send(sub, WithTimestamp(init, 0))
+			}
+		}
+		sub.primed = true
+		sub.waitKeyframe = HasVideo(h.header)
+
+		if IsConfig(tag) {
+			// Already sent as part of the initial configuration.
+			return
+		}
+	}
+
+	if sub.waitKeyframe && IsConfig(tag) {
+		h.send(sub, WithTimestamp(tag, 0))
+		return
+	}
+
+	if sub.waitKeyframe {
+		if !IsVideoKeyframe(tag) {
+			return
+		}
+		sub.waitKeyframe = false
+		sub.baseTS = Timestamp(tag)
+	}
+
+	ts := Timestamp(tag)
+	if ts < sub.baseTS {
+		ts = sub.baseTS
+	}
+	h.send(sub, WithTimestamp(tag, ts-sub.baseTS))
+}
+
+func (h *Hub) send(sub *Subscription, data []byte) {
+	if sub.closed {
+		return
+	}
+
+	select {
+	case sub.tags <- data:
+	default:
+		log.Warnf("flv: dropping subscriber that is falling behind")
+		h.closeSubscription(sub)
+	}
+}
+
+func (h *Hub) closeSubscription(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.tags)
+	delete(h.subscriptions, sub)
+}
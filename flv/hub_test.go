@@ -0,0 +1,209 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var (
+	testMetadata    = NewTag(TagScript, 0, []byte{2, 0, 10})
+	testVideoConfig = NewTag(TagVideo, 0, []byte{0x17, 0})
+	testAudioConfig = NewTag(TagAudio, 0, []byte{0xaf, 0})
+)
+
+func keyframe(ts uint32) []byte {
+	return NewTag(TagVideo, ts, []byte{0x17, 1, byte(ts)})
+}
+
+func interFrame(ts uint32) []byte {
+	return NewTag(TagVideo, ts, []byte{0x27, 1, byte(ts)})
+}
+
+func audioFrame(ts uint32) []byte {
+	return NewTag(TagAudio, ts, []byte{0xaf, 1, byte(ts)})
+}
+
+func at(tag []byte, ts uint32) []byte {
+	return WithTimestamp(tag, ts)
+}
+
+// received drains sub and returns what it was sent.
+func received(h *Hub, sub *Subscription) [][]byte {
+	h.Unsubscribe(sub)
+
+	var tags [][]byte
+	for tag := range sub.tags {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+func TestHubSubscribe(t *testing.T) {
+	header := NewHeader(true, true)
+	audioOnly := NewHeader(true, false)
+
+	// The tags in before are published before subscribing, and those in
+	// after once subscribed.
+	tests := []struct {
+		name   string
+		header []byte
+		before [][]byte
+		after  [][]byte
+		want   [][]byte
+	}{
+		{
+			name:   "from the start",
+			header: header,
+			after:  [][]byte{testMetadata, testVideoConfig, keyframe(0), interFrame(33)},
+			want:   [][]byte{header, testMetadata, testVideoConfig, keyframe(0), interFrame(33)},
+		},
+		{
+			name:   "mid-stream waits for a keyframe",
+			header: header,
+			before: [][]byte{testMetadata, testVideoConfig, testAudioConfig, keyframe(0), interFrame(33)},
+			after:  [][]byte{interFrame(66), audioFrame(80), keyframe(100), interFrame(133), audioFrame(120)},
+			want: [][]byte{
+				header, testMetadata, testVideoConfig, testAudioConfig,
+				at(keyframe(100), 0), at(interFrame(133), 33), at(audioFrame(120), 20),
+			},
+		},
+		{
+			name:   "configuration changes before the keyframe",
+			header: header,
+			before: [][]byte{testVideoConfig, keyframe(0)},
+			after:  [][]byte{interFrame(33), NewTag(TagVideo, 50, []byte{0x17, 0, 9}), keyframe(100)},
+			want: [][]byte{
+				header, testVideoConfig, NewTag(TagVideo, 0, []byte{0x17, 0, 9}), at(keyframe(100), 0),
+			},
+		},
+		{
+			name:   "audio only",
+			header: audioOnly,
+			before: [][]byte{testAudioConfig, audioFrame(0)},
+			after:  [][]byte{audioFrame(20), audioFrame(40)},
+			want:   [][]byte{audioOnly, testAudioConfig, audioFrame(20), audioFrame(40)},
+		},
+		{
+			name:   "timestamps before the keyframe",
+			header: header,
+			after:  [][]byte{keyframe(100), audioFrame(90), interFrame(133)},
+			want:   [][]byte{header, at(keyframe(100), 0), at(audioFrame(90), 0), at(interFrame(133), 33)},
+		},
+		{
+			name:  "no header",
+			after: [][]byte{keyframe(0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			if tt.header != nil {
+				h.SetHeader(tt.header)
+			}
+			for _, tag := range tt.before {
+				h.Publish(tag)
+			}
+
+			sub := h.Subscribe()
+			for _, tag := range tt.after {
+				h.Publish(tag)
+			}
+
+			got := received(h, sub)
+			if len(got) != len(tt.want) {
+				t.Fatalf("received %d tags, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("tag %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHubReadFrom(t *testing.T) {
+	header := NewHeader(true, true)
+	tags := [][]byte{testMetadata, testVideoConfig, keyframe(0), audioFrame(10)}
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    int
+		wantErr error
+	}{
+		{"complete", bytes.Join(append([][]byte{header}, tags...), nil), len(tags) + 1, io.EOF},
+		{"truncated tag", bytes.Join(append([][]byte{header}, tags[0], tags[1][:4]), nil), 2, io.ErrUnexpectedEOF},
+		{"truncated header", header[:4], 0, io.ErrUnexpectedEOF},
+		{"not FLV", []byte("GIF89a\x00\x00\x00\x00\x00\x00\x00"), 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			sub := h.Subscribe()
+
+			_, err := h.ReadFrom(bytes.NewReader(tt.input))
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				t.Fatal("ReadFrom returned without an error")
+			}
+
+			if got := len(received(h, sub)); got != tt.want {
+				t.Fatalf("received %d tags, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	h.SetHeader(NewHeader(false, true))
+
+	slow := h.Subscribe()
+	fast := h.Subscribe()
+	h.Publish(keyframe(0))
+	<-fast.tags
+	<-fast.tags
+
+	for i := 0; i < subscriptionBufSize; i++ {
+		h.Publish(interFrame(uint32(i)))
+		<-fast.tags
+	}
+
+	if _, ok := h.subscriptions[slow]; ok {
+		t.Fatal("subscriber that is falling behind was not dropped")
+	}
+	if _, ok := h.subscriptions[fast]; !ok {
+		t.Fatal("subscriber that keeps up was dropped")
+	}
+	if n := len(received(h, slow)); n != subscriptionBufSize {
+		t.Fatalf("slow subscriber received %d tags, want %d", n, subscriptionBufSize)
+	}
+}
+
+func TestHubReset(t *testing.T) {
+	h := NewHub()
+	h.SetHeader(NewHeader(false, true))
+	h.Publish(testVideoConfig)
+
+	sub := h.Subscribe()
+	h.Reset()
+
+	if _, ok := <-sub.tags; ok {
+		t.Fatal("subscription is still open after reset")
+	}
+
+	// Subscriptions after a reset only get the configuration of the new
+	// stream.
+	sub = h.Subscribe()
+	h.SetHeader(NewHeader(false, true))
+	h.Publish(keyframe(0))
+	if n := len(received(h, sub)); n != 2 {
+		t.Fatalf("received %d tags after reset, want 2", n)
+	}
+}
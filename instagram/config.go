@@ -7,7 +7,6 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/http/cookiejar"
 	neturl "net/url"
 	"time"
 )
@@ -26,10 +25,11 @@ type config struct {
 	PhoneID   string         `json:"phone_id"`
 	SessionID string         `json:"session_id"`
 	Cookies   []*http.Cookie `json:"cookies"`
+	Proxy     string         `json:"proxy,omitempty"`
 }
 
 func exportConfig(client *Instagram, writer io.Writer) error {
-	url, err := neturl.Parse(igBaseURL)
+	url, err := neturl.Parse(client.baseURL)
 	if err != nil {
 		return err
 	}
@@ -46,6 +46,10 @@ func exportConfig(client *Instagram, writer io.Writer) error {
 		Cookies:   client.httpClient.Jar.Cookies(url),
 	}
 
+	if client.proxy != nil {
+		config.Proxy = client.proxy.String()
+	}
+
 	jsonBytes, err := json.Marshal(config)
 	if err != nil {
 		return err
@@ -66,7 +70,7 @@ func ExportToString(client *Instagram) (string, error) {
 	return encoded, nil
 }
 
-func importConfig(config config) (*Instagram, error) {
+func importConfig(config config, opts ...Option) (*Instagram, error) {
 	client := &Instagram{
 		username:  config.Username,
 		deviceID:  config.DeviceID,
@@ -74,21 +78,22 @@ func importConfig(config config) (*Instagram, error) {
 		rankToken: config.RankToken,
 		token:     config.Token,
 		phoneID:   config.PhoneID,
-		httpClient: &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-			},
-			Timeout: httpTimeout,
-		},
 	}
 
-	jar, err := cookiejar.New(nil)
-	if err != nil {
+	// The proxy of the exported client is kept unless WithProxy overrides it.
+	if config.Proxy != "" {
+		proxy, err := ParseProxy(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		client.proxy = proxy
+	}
+
+	if err := client.init(opts...); err != nil {
 		return nil, err
 	}
-	client.httpClient.Jar = jar
 
-	baseURL, err := neturl.Parse(igBaseURL)
+	baseURL, err := neturl.Parse(client.baseURL)
 	if err != nil {
 		return nil, err
 	}
@@ -97,14 +102,12 @@ func importConfig(config config) (*Instagram, error) {
 		Value: config.SessionID,
 	}})
 
-	apiBaseURL, err := neturl.Parse(igAPIBaseURL)
+	apiBaseURL, err := neturl.Parse(client.apiURL(false))
 	if err != nil {
 		return nil, err
 	}
 	client.httpClient.Jar.SetCookies(apiBaseURL, config.Cookies)
 
-	client.init()
-
 	client.Account = &Account{client: client, ID: config.ID}
 	err = client.Account.Sync()
 	if err != nil {
@@ -114,7 +117,7 @@ func importConfig(config config) (*Instagram, error) {
 	return client, nil
 }
 
-func ImportFromString(base64String string) (*Instagram, error) {
+func ImportFromString(base64String string, opts ...Option) (*Instagram, error) {
 	decoded, err := base64.StdEncoding.DecodeString(base64String)
 	if err != nil {
 		return nil, err
@@ -131,5 +134,5 @@ func ImportFromString(base64String string) (*Instagram, error) {
 		return nil, err
 	}
 
-	return importConfig(config)
+	return importConfig(config, opts...)
 }
@@ -2,8 +2,8 @@ package instagram
 
 const (
 	igBaseURL       = "https://i.instagram.com"
-	igAPIBaseURL    = igBaseURL + "/api/v1"
-	igAPIBaseURLV2  = igBaseURL + "/api/v2"
+	igAPIPath       = "/api/v1"
+	igAPIPathV2     = "/api/v2"
 	igSigKey        = "c36436a942ea1dbb40d7f2d7d45280a620d991ce8c62fb4ce600f0a048c32c11"
 	igSigKeyVersion = "4"
 	igUserAgent     = "Instagram 107.0.0.27.121 Android (24/7.0; 380dpi; 1080x1920; OnePlus; ONEPLUS A3010; OnePlus3T; qcom; en_US)"
@@ -3,6 +3,7 @@ package instagram
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"image/jpeg"
 	"image/png"
 	"io/ioutil"
@@ -24,7 +25,16 @@ type Instagram struct {
 	token        string
 	challengeURL string
 	sessionID    string
+	baseURL      string
 	httpClient   *http.Client
+	observer     RequestObserver
+	retryPolicy  RetryPolicy
+	rateLimits   RateLimits
+	limiter      *rateLimiter
+	proxy        *url.URL
+	// initErr fails every request of a client that could not be set up as
+	// configured.
+	initErr error
 
 	Account   *Account
 	Live      *Live
@@ -35,8 +45,34 @@ type LogoutResponse struct {
 	Status string `json:"status"`
 }
 
-func New(username, password string) *Instagram {
-	jar, _ := cookiejar.New(nil)
+// Option configures optional behaviour of an Instagram client.
+type Option func(*Instagram)
+
+// WithBaseURL makes the client talk to the given base URL instead of
+// the real Instagram API, e.g. a fakeig server.
+func WithBaseURL(baseURL string) Option {
+	return func(i *Instagram) {
+		i.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithHTTPClient replaces the default HTTP client. A cookie jar is
+// attached to the client if it does not already have one.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(i *Instagram) {
+		i.httpClient = httpClient
+	}
+}
+
+// WithRequestObserver calls observer after every API request, e.g. to
+// record metrics.
+func WithRequestObserver(observer RequestObserver) Option {
+	return func(i *Instagram) {
+		i.observer = observer
+	}
+}
+
+func New(username, password string, opts ...Option) *Instagram {
 	client := &Instagram{
 		username: username,
 		password: password,
@@ -45,23 +81,51 @@ func New(username, password string) *Instagram {
 		),
 		uuid:    generateUUID(),
 		phoneID: generateUUID(),
-		httpClient: &http.Client{
-			Transport: &http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-			},
-			Timeout: httpTimeout,
-			Jar:     jar,
-		},
 	}
 
-	client.init()
+	client.initErr = client.init(opts...)
 
 	return client
 }
 
-func (i *Instagram) init() {
+func (i *Instagram) init(opts ...Option) error {
+	i.baseURL = igBaseURL
+	i.retryPolicy = DefaultRetryPolicy
+	i.rateLimits = DefaultRateLimits
+	i.httpClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+		},
+		Timeout: httpTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	if i.httpClient.Jar == nil {
+		jar, _ := cookiejar.New(nil)
+		i.httpClient.Jar = jar
+	}
+
+	i.limiter = newRateLimiter(i.rateLimits)
+
 	i.Live = newLive(i)
 	i.Challenge = newChallenge(i)
+
+	if i.proxy != nil {
+		if err := i.useProxy(); err != nil {
+			return fmt.Errorf("instagram: unable to use proxy %s: %v", i.Proxy(), err)
+		}
+	}
+	return nil
+}
+
+func (i *Instagram) apiURL(useV2 bool) string {
+	if useV2 {
+		return i.baseURL + igAPIPathV2
+	}
+	return i.baseURL + igAPIPath
 }
 
 func (i *Instagram) readMSISDNHeader() error {
@@ -227,7 +291,7 @@ func (i *Instagram) Login() error {
 		return err
 	}
 
-	cookieURL, _ := url.Parse(igBaseURL)
+	cookieURL, _ := url.Parse(i.baseURL)
 	for _, value := range i.httpClient.Jar.Cookies(cookieURL) {
 		if strings.Contains(value.Name, "sessionid") {
 			i.sessionID = value.Value
@@ -279,6 +343,10 @@ func (i *Instagram) Logout() (*LogoutResponse, error) {
 }
 
 func (i *Instagram) GetThumbnailAsJPEG(url string, quality int) ([]byte, error) {
+	if i.initErr != nil {
+		return nil, i.initErr
+	}
+
 	inBuffer := bytes.NewBuffer([]byte{})
 	var req *http.Request
 
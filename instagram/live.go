@@ -1,6 +1,7 @@
 package instagram
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/google/uuid"
@@ -249,6 +250,12 @@ func (live *Live) End(broadcastID int, endAfterCopyrightWarning bool) (*LiveEndR
 }
 
 func (live *Live) Info(broadcastID int) (*LiveInfoResponse, error) {
+	return live.InfoContext(context.Background(), broadcastID)
+}
+
+// InfoContext is like Info, but gives up on retrying the request when ctx
+// is done.
+func (live *Live) InfoContext(ctx context.Context, broadcastID int) (*LiveInfoResponse, error) {
 	client := live.client
 
 	data, err := client.prepareData(
@@ -260,9 +267,11 @@ func (live *Live) Info(broadcastID int) (*LiveInfoResponse, error) {
 
 	body, err := client.sendRequest(
 		&reqOptions{
-			Endpoint: fmt.Sprintf(igAPILiveInfo, broadcastID),
-			IsPost:   false,
-			Query:    generateSignature(data),
+			Context:    ctx,
+			Endpoint:   fmt.Sprintf(igAPILiveInfo, broadcastID),
+			IsPost:     false,
+			Query:      generateSignature(data),
+			Idempotent: true,
 		},
 	)
 	if err != nil {
@@ -339,6 +348,12 @@ func (live *Live) DisableRequestToJoin(broadcastID int) (*LiveDisableRequestToJo
 }
 
 func (live *Live) GetComment(broadcastID int, numCommentsRequested int, lastCommentTS int) (*LiveGetCommentResponse, error) {
+	return live.GetCommentContext(context.Background(), broadcastID, numCommentsRequested, lastCommentTS)
+}
+
+// GetCommentContext is like GetComment, but gives up on retrying the
+// request when ctx is done.
+func (live *Live) GetCommentContext(ctx context.Context, broadcastID int, numCommentsRequested int, lastCommentTS int) (*LiveGetCommentResponse, error) {
 	client := live.client
 
 	data, err := client.prepareData(
@@ -350,9 +365,11 @@ func (live *Live) GetComment(broadcastID int, numCommentsRequested int, lastComm
 
 	body, err := client.sendRequest(
 		&reqOptions{
-			Endpoint: fmt.Sprintf(igAPILiveGetComment, broadcastID),
-			Query:    generateSignature(data),
-			IsPost:   false,
+			Context:    ctx,
+			Endpoint:   fmt.Sprintf(igAPILiveGetComment, broadcastID),
+			Query:      generateSignature(data),
+			IsPost:     false,
+			Idempotent: true,
 		},
 	)
 	if err != nil {
@@ -438,6 +455,12 @@ func (live *Live) PinComment(broadcastID int, commentID int64) (*LivePinCommentR
 }
 
 func (live *Live) HeartbeatAndGetViewerCount(broadcastID int) (*LiveHeartbeatAndGetViewerCountResponse, error) {
+	return live.HeartbeatAndGetViewerCountContext(context.Background(), broadcastID)
+}
+
+// HeartbeatAndGetViewerCountContext is like HeartbeatAndGetViewerCount, but
+// gives up on retrying the request when ctx is done.
+func (live *Live) HeartbeatAndGetViewerCountContext(ctx context.Context, broadcastID int) (*LiveHeartbeatAndGetViewerCountResponse, error) {
 	client := live.client
 
 	data, err := client.prepareData(
@@ -451,9 +474,11 @@ func (live *Live) HeartbeatAndGetViewerCount(broadcastID int) (*LiveHeartbeatAnd
 
 	body, err := client.sendRequest(
 		&reqOptions{
-			Endpoint: fmt.Sprintf(igAPILiveHeartbeatAndGetViewerCount, broadcastID),
-			IsPost:   true,
-			Query:    generateSignature(data),
+			Context:    ctx,
+			Endpoint:   fmt.Sprintf(igAPILiveHeartbeatAndGetViewerCount, broadcastID),
+			IsPost:     true,
+			Query:      generateSignature(data),
+			Idempotent: true,
 		},
 	)
 	if err != nil {
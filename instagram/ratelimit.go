@@ -0,0 +1,96 @@
+package instagram
+
+import (
+	"context"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// RateLimit is a token bucket that allows Burst requests at once and is
+// refilled at PerMinute requests per minute. A PerMinute of zero disables
+// the limit.
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// RateLimits are the request budgets of a single account. Endpoints are
+// keyed by their path with IDs replaced, e.g. "/live/{id}/get_comment/".
+// Endpoints without a budget of their own share the Default budget.
+type RateLimits struct {
+	Default   RateLimit
+	Endpoints map[string]RateLimit
+}
+
+var DefaultRateLimits = RateLimits{
+	Default: RateLimit{PerMinute: 60, Burst: 10},
+	Endpoints: map[string]RateLimit{
+		"/live/{id}/get_comment/":                    {PerMinute: 60, Burst: 3},
+		"/live/{id}/heartbeat_and_get_viewer_count/": {PerMinute: 60, Burst: 3},
+		"/live/{id}/info/":                           {PerMinute: 30, Burst: 3},
+		"/live/{id}/comment/":                        {PerMinute: 6, Burst: 2},
+		"/live/{id}/pin_comment/":                    {PerMinute: 6, Burst: 2},
+		"/live/{id}/unmute_comment/":                 {PerMinute: 6, Burst: 2},
+		"/live/{id}/disable_request_to_join/":        {PerMinute: 6, Burst: 2},
+	},
+}
+
+// WithRateLimits replaces DefaultRateLimits.
+func WithRateLimits(limits RateLimits) Option {
+	return func(i *Instagram) {
+		i.rateLimits = limits
+	}
+}
+
+type rateLimiter struct {
+	fallback  *rate.Limiter
+	endpoints map[string]*rate.Limiter
+}
+
+func newRateLimiter(limits RateLimits) *rateLimiter {
+	l := &rateLimiter{
+		fallback:  newLimiter(limits.Default),
+		endpoints: make(map[string]*rate.Limiter),
+	}
+	for endpoint, limit := range limits.Endpoints {
+		l.endpoints[endpoint] = newLimiter(limit)
+	}
+	return l
+}
+
+func newLimiter(limit RateLimit) *rate.Limiter {
+	if limit.PerMinute <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(limit.PerMinute/60), burst)
+}
+
+// wait blocks until the budget of endpoint allows another request or ctx
+// is done, and returns the time spent waiting.
+func (l *rateLimiter) wait(ctx context.Context, endpoint string) (time.Duration, error) {
+	limiter, ok := l.endpoints[endpoint]
+	if !ok {
+		limiter = l.fallback
+	}
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay <= 0 {
+		return 0, nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return delay, nil
+	case <-ctx.Done():
+		// Give the budget back to requests that are still wanted.
+		reservation.Cancel()
+		return 0, ctx.Err()
+	}
+}
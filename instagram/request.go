@@ -2,6 +2,7 @@ package instagram
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
@@ -15,12 +16,33 @@ import (
 	"time"
 )
 
+// RequestInfo describes a completed API request.
+type RequestInfo struct {
+	// Endpoint is the request path with IDs replaced by {id}, so that it
+	// can be used as a metric label, e.g. /live/{id}/start/.
+	Endpoint   string
+	Method     string
+	StatusCode int
+	Duration   time.Duration
+	// Wait is the time spent waiting for the rate limiter before the
+	// request was sent. It is not included in Duration.
+	Wait time.Duration
+	Err  error
+}
+
+type RequestObserver func(RequestInfo)
+
 type reqOptions struct {
+	// Context cancels the request and any retries of it. It defaults to
+	// context.Background().
+	Context    context.Context
 	Connection string
 	Endpoint   string
 	IsPost     bool
 	UseV2      bool
 	Query      map[string]string
+	// Idempotent requests are retried according to the retry policy.
+	Idempotent bool
 }
 
 var uploadPhotoResponse struct {
@@ -104,7 +126,23 @@ func (i *Instagram) prepareData(other ...map[string]interface{}) (string, error)
 	return "", err
 }
 
-func (i *Instagram) sendRequest(options *reqOptions) (body []byte, err error) {
+func (i *Instagram) sendRequest(options *reqOptions) ([]byte, error) {
+	if options.Context == nil {
+		options.Context = context.Background()
+	}
+	if !options.Idempotent {
+		return i.doRequest(options)
+	}
+	return i.withRetry(options.Context, options.Endpoint, func() ([]byte, error) {
+		return i.doRequest(options)
+	})
+}
+
+func (i *Instagram) doRequest(options *reqOptions) (body []byte, err error) {
+	if i.initErr != nil {
+		return nil, i.initErr
+	}
+
 	method := "GET"
 	if options.IsPost {
 		method = "POST"
@@ -114,12 +152,7 @@ func (i *Instagram) sendRequest(options *reqOptions) (body []byte, err error) {
 		options.Connection = "keep-alive"
 	}
 
-	baseURL := igAPIBaseURL
-	if options.UseV2 {
-		baseURL = igAPIBaseURLV2
-	}
-
-	reqURL, err := url.Parse(baseURL + options.Endpoint)
+	reqURL, err := url.Parse(i.apiURL(options.UseV2) + options.Endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -142,7 +175,7 @@ func (i *Instagram) sendRequest(options *reqOptions) (body []byte, err error) {
 	}
 
 	var req *http.Request
-	req, err = http.NewRequest(method, reqURL.String(), buffer)
+	req, err = http.NewRequestWithContext(options.Context, method, reqURL.String(), buffer)
 	if err != nil {
 		return
 	}
@@ -159,14 +192,36 @@ func (i *Instagram) sendRequest(options *reqOptions) (body []byte, err error) {
 	req.Header.Set("X-IG-Bandwidth-TotalBytes-B", "0")
 	req.Header.Set("X-IG-Bandwidth-TotalTime-MS", "0")
 
+	endpoint := normalizeEndpoint(options.Endpoint)
+	wait, err := i.limiter.wait(options.Context, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode := 0
+	if i.observer != nil {
+		start := time.Now()
+		defer func() {
+			i.observer(RequestInfo{
+				Endpoint:   endpoint,
+				Method:     method,
+				StatusCode: statusCode,
+				Duration:   time.Since(start),
+				Wait:       wait,
+				Err:        err,
+			})
+		}()
+	}
+
 	log.Tracef("Request %s %s", method, reqURL)
 	resp, err := i.httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	statusCode = resp.StatusCode
 
-	cookieURL, _ := url.Parse(igAPIBaseURL)
+	cookieURL, _ := url.Parse(i.apiURL(false))
 	for _, value := range i.httpClient.Jar.Cookies(cookieURL) {
 		if strings.Contains(value.Name, "csrftoken") {
 			i.token = value.Value
@@ -176,19 +231,51 @@ func (i *Instagram) sendRequest(options *reqOptions) (body []byte, err error) {
 	body, err = ioutil.ReadAll(resp.Body)
 	log.Tracef("Response %s %s: %d: %s", method, reqURL, resp.StatusCode, string(body))
 	if err == nil {
-		err = checkError(resp.StatusCode, body)
+		err = checkError(resp.StatusCode, resp.Header, body)
 	}
 
 	return body, err
 }
 
-func checkError(code int, body []byte) (err error) {
+// normalizeEndpoint replaces every path segment that contains a digit,
+// such as a broadcast ID or a challenge nonce, with {id}.
+func normalizeEndpoint(endpoint string) string {
+	if i := strings.IndexByte(endpoint, '?'); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+
+	segments := strings.Split(endpoint, "/")
+	for i, segment := range segments {
+		if strings.ContainsAny(segment, "0123456789") {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func checkError(code int, header http.Header, body []byte) (err error) {
 	if code == 200 {
 		return nil
 	}
 
-	switch code {
-	case 400:
+	switch {
+	case code == http.StatusTooManyRequests:
+		httpErr := &HTTPGenericError{}
+		_ = json.Unmarshal(body, httpErr)
+		return &RateLimitError{
+			Message:    httpErr.Message,
+			RetryAfter: parseRetryAfter(header),
+		}
+	case code >= 500:
+		httpErr := &HTTPGenericError{}
+		if json.Unmarshal(body, httpErr) != nil {
+			httpErr.Message = http.StatusText(code)
+		}
+		return &ServerError{
+			StatusCode: code,
+			Message:    httpErr.Message,
+		}
+	case code == 400:
 		httpErr := &HTTPGenericError{}
 		err = json.Unmarshal(body, httpErr)
 		if err != nil {
@@ -203,7 +290,7 @@ func checkError(code int, body []byte) (err error) {
 			return &httpErr.ChallengeError
 		}
 		return httpErr
-	case 403:
+	case code == 403:
 		httpErr := &HTTPGenericError{}
 		err = json.Unmarshal(body, httpErr)
 		if err != nil {
@@ -229,6 +316,10 @@ func checkError(code int, body []byte) (err error) {
 }
 
 func (i *Instagram) UploadPhoto(photo io.Reader) (string, error) {
+	if i.initErr != nil {
+		return "", i.initErr
+	}
+
 	uploadID := time.Now().Unix()
 	rndNumber := rand.Intn(9999999999-1000000000) + 1000000000
 	name := strconv.FormatInt(uploadID, 10) + "_0_" + strconv.Itoa(rndNumber)
@@ -239,7 +330,7 @@ func (i *Instagram) UploadPhoto(photo io.Reader) (string, error) {
 		return "", err
 	}
 
-	req, err := http.NewRequest("POST", igBaseURL+igAPIUploadPhoto+name, inBuffer)
+	req, err := http.NewRequest("POST", i.baseURL+igAPIUploadPhoto+name, inBuffer)
 	if err != nil {
 		return "", err
 	}
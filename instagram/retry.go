@@ -0,0 +1,146 @@
+package instagram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how idempotent requests, such as heartbeats and
+// comment polling, are retried after a retryable error.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomises each backoff by up to this fraction of it.
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(i *Instagram) {
+		i.retryPolicy = policy
+	}
+}
+
+// RateLimitError is returned when Instagram responds with 429 Too Many
+// Requests.
+type RateLimitError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited, retry after %v: %s", e.RetryAfter, e.Message)
+	}
+	return fmt.Sprintf("rate limited: %s", e.Message)
+}
+
+// ServerError is returned when Instagram responds with a 5xx status.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e ServerError) Error() string {
+	return fmt.Sprintf("server error %d: %s", e.StatusCode, e.Message)
+}
+
+// IsRetryable reports whether a request that failed with err may succeed
+// when repeated. Rate limits, server errors, timeouts and connections that
+// were reset or closed early are retryable; everything else, e.g. a
+// challenge, an expired session or a TLS or proxy failure, is not.
+func IsRetryable(err error) bool {
+	switch err := err.(type) {
+	case *RateLimitError, *ServerError:
+		return true
+	case net.Error:
+		if err.Timeout() {
+			return true
+		}
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// retryable, the policy runs out of attempts or ctx is done. A Retry-After
+// that is longer than the maximum backoff is not waited for, so that the
+// caller can back off on its own.
+func (i *Instagram) withRetry(ctx context.Context, endpoint string, fn func() ([]byte, error)) ([]byte, error) {
+	p := i.retryPolicy
+
+	for attempt := 1; ; attempt++ {
+		body, err := fn()
+		if err == nil || !IsRetryable(err) || attempt >= p.MaxAttempts {
+			return body, err
+		}
+
+		delay := p.backoff(attempt - 1)
+		if e, ok := err.(*RateLimitError); ok && e.RetryAfter > 0 {
+			if e.RetryAfter > p.MaxBackoff {
+				return body, err
+			}
+			delay = e.RetryAfter
+		}
+
+		log.Warnf("instagram: %s: attempt %d of %d failed, retrying in %v: %v",
+			normalizeEndpoint(endpoint), attempt, p.MaxAttempts, delay.Round(time.Millisecond), err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return body, err
+		}
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or
+// as an HTTP date.
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
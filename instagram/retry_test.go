@@ -0,0 +1,93 @@
+package instagram
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func urlError(err error) error {
+	return &url.Error{Op: "Post", URL: "https://i.instagram.com/api/v1/", Err: err}
+}
+
+func TestIsRetryable(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limited", &RateLimitError{}, true},
+		{"server error", &ServerError{StatusCode: 502}, true},
+		{"timeout", urlError(timeoutError{}), true},
+		{"connection reset", urlError(reset), true},
+		{"EOF", urlError(io.EOF), true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"connection refused", urlError(refused), false},
+		{"certificate", urlError(x509.UnknownAuthorityError{}), false},
+		{"proxy authentication", urlError(errors.New("proxyconnect tcp: 407 Proxy Authentication Required")), false},
+		{"canceled", urlError(context.Canceled), false},
+		{"challenge", &ChallengeError{}, false},
+		{"login required", &LoginRequiredError{}, false},
+		{"other", fmt.Errorf("bad request"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Fatalf("IsRetryable(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetryStopsWhenContextIsDone(t *testing.T) {
+	i := &Instagram{retryPolicy: RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+		MaxBackoff:     time.Hour,
+		Multiplier:     1,
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	done := make(chan error, 1)
+	go func() {
+		_, err := i.withRetry(ctx, "/live/1/heartbeat_and_get_viewer_count/", func() ([]byte, error) {
+			attempts++
+			return nil, &ServerError{StatusCode: 503}
+		})
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if _, ok := err.(*ServerError); !ok {
+			t.Fatalf("error = %v, want the last error of the request", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("withRetry kept waiting after the context was canceled")
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+}
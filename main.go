@@ -1,33 +1,115 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/sbekti/broadcastd/broadcast"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const (
 	defaultConfig = "/etc/broadcastd/config.yaml"
 )
 
+const usage = `Usage: broadcastd [command] [flags]
+
+Commands:
+  serve                     run the daemon (default)
+  validate                  check the config file
+  login <account>           log in to an account on the terminal
+  token export <account>    print the token of an account
+  token import <account>    save a token read from standard input
+  token revoke <account>    log out and remove the token of an account
+  status                    show the status of a running daemon
+
+Run 'broadcastd <command> -h' for the flags of a command.
+`
+
 func main() {
-	var configPath string
-	flag.StringVar(&configPath, "c", defaultConfig, "path to config file")
-	flag.Parse()
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		serve(args)
+	case "validate":
+		validate(args)
+	case "login":
+		login(args)
+	case "token":
+		token(args)
+	case "status":
+		status(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "broadcastd: unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+}
+
+// fatalf prints an error for a command and exits.
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// validate checks the config file and prints every problem found in it.
+func validate(args []string) {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	fs.Parse(args)
+
+	if _, err := broadcast.LoadHostConfig(*configPath); err != nil {
+		fatalf("%v", err)
+	}
+	fmt.Printf("%s is valid\n", *configPath)
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	printConfig := fs.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	fs.Parse(args)
 
-	c, err := broadcast.LoadConfig(configPath)
+	c, err := broadcast.LoadHostConfig(*configPath)
+	if verr, ok := err.(*broadcast.ValidationError); ok {
+		for _, problem := range verr.Problems {
+			log.Errorf("config: %s", problem)
+		}
+		log.Fatalf("config: %s is invalid", *configPath)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *printConfig {
+		b, err := c.RedactedYAML()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(b)
+		return
+	}
+
 	level, err := log.ParseLevel(c.LogLevel)
 	if err != nil {
 		log.Fatal(err)
 	}
 	log.SetLevel(level)
 
+	if err := c.MigrateCredentials(); err != nil {
+		log.Fatal(err)
+	}
+
 	b := broadcast.NewBroadcast(c)
 
 	go func() {
@@ -36,11 +118,37 @@ func main() {
 		}
 	}()
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if _, err := b.Reload(); err != nil {
+				log.Errorf("unable to reload config: %v", err)
+			}
+		}
+	}()
+
+	quit := make(chan os.Signal, 2)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	sig := <-quit
 
-	if err := b.Stop(); err != nil {
-		log.Fatal(err)
+	timeout := time.Duration(b.Config().ShutdownTimeout) * time.Second
+	log.Infof("received %v, ending broadcasts within %v, send it again to exit immediately", sig, timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	go func() {
+		sig := <-quit
+		log.Warnf("received %v again, exiting without ending broadcasts", sig)
+		cancel()
+	}()
+
+	// Shutdown returns once the recovery state is written, even if
+	// broadcasts are still being ended.
+	if err := b.Shutdown(ctx); err != nil {
+		log.Error(err)
+		os.Exit(1)
 	}
+	log.Info("stopped")
 }
@@ -0,0 +1,209 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+// amfObj is a decoded AMF0 object or ECMA array.
+type amfObj map[string]interface{}
+
+func (o amfObj) str(key string) string {
+	s, _ := o[key].(string)
+	return s
+}
+
+func (o amfObj) num(key string) float64 {
+	n, _ := o[key].(float64)
+	return n
+}
+
+// decodeAMF decodes every AMF0 value in data.
+func decodeAMF(data []byte) ([]interface{}, error) {
+	r := bytes.NewReader(data)
+
+	var values []interface{}
+	for r.Len() > 0 {
+		v, err := decodeAMFValue(r)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func decodeAMFValue(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch marker {
+	case amfNumber:
+		var bits uint64
+		if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(bits), nil
+	case amfBoolean:
+		b, err := r.ReadByte()
+		return b != 0, err
+	case amfString:
+		return decodeAMFString(r)
+	case amfLongString:
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		return readAMFString(r, int(size))
+	case amfObject:
+		return decodeAMFProperties(r)
+	case amfECMAArray:
+		// The count is only a hint, the array is terminated like an object.
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return decodeAMFProperties(r)
+	case amfStrictArray:
+		var count uint32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		for i := uint32(0); i < count; i++ {
+			v, err := decodeAMFValue(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case amfDate:
+		if _, err := r.Seek(10, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case amfNull, amfUndefined:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("rtmp: unsupported AMF0 type 0x%02x", marker)
+	}
+}
+
+func decodeAMFString(r *bytes.Reader) (string, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", err
+	}
+	return readAMFString(r, int(size))
+}
+
+func readAMFString(r *bytes.Reader, size int) (string, error) {
+	if size > r.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeAMFProperties(r *bytes.Reader) (amfObj, error) {
+	obj := make(amfObj)
+	for {
+		key, err := decodeAMFString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "" {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if marker == amfObjectEnd {
+				return obj, nil
+			}
+			if err := r.UnreadByte(); err != nil {
+				return nil, err
+			}
+		}
+
+		v, err := decodeAMFValue(r)
+		if err != nil {
+			return nil, err
+		}
+		obj[key] = v
+	}
+}
+
+// encodeAMF encodes values as AMF0. Supported types are float64, int,
+// bool, string, amfObj and nil.
+func encodeAMF(values ...interface{}) []byte {
+	buf := &bytes.Buffer{}
+	for _, v := range values {
+		encodeAMFValue(buf, v)
+	}
+	return buf.Bytes()
+}
+
+func encodeAMFValue(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case float64:
+		buf.WriteByte(amfNumber)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case int:
+		encodeAMFValue(buf, float64(v))
+	case bool:
+		buf.WriteByte(amfBoolean)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		buf.WriteByte(amfString)
+		encodeAMFString(buf, v)
+	case amfObj:
+		buf.WriteByte(amfObject)
+
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			encodeAMFString(buf, key)
+			encodeAMFValue(buf, v[key])
+		}
+		buf.Write([]byte{0, 0, amfObjectEnd})
+	default:
+		buf.WriteByte(amfNull)
+	}
+}
+
+func encodeAMFString(buf *bytes.Buffer, s string) {
+	_ = binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
@@ -0,0 +1,193 @@
+package rtmp
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeAMF(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  []interface{}
+		err   string
+	}{
+		{
+			name:  "number",
+			input: []byte{amfNumber, 0x40, 0x59, 0, 0, 0, 0, 0, 0},
+			want:  []interface{}{100.0},
+		},
+		{
+			name:  "booleans",
+			input: []byte{amfBoolean, 1, amfBoolean, 0},
+			want:  []interface{}{true, false},
+		},
+		{
+			name:  "string",
+			input: []byte{amfString, 0, 7, 'c', 'o', 'n', 'n', 'e', 'c', 't'},
+			want:  []interface{}{"connect"},
+		},
+		{
+			name:  "long string",
+			input: []byte{amfLongString, 0, 0, 0, 2, 'h', 'i'},
+			want:  []interface{}{"hi"},
+		},
+		{
+			name:  "null and undefined",
+			input: []byte{amfNull, amfUndefined},
+			want:  []interface{}{nil, nil},
+		},
+		{
+			name: "object",
+			input: []byte{
+				amfObject,
+				0, 3, 'a', 'p', 'p', amfString, 0, 4, 'l', 'i', 'v', 'e',
+				0, 0, amfObjectEnd,
+			},
+			want: []interface{}{amfObj{"app": "live"}},
+		},
+		{
+			name: "ECMA array",
+			input: []byte{
+				amfECMAArray, 0, 0, 0, 1,
+				0, 5, 'w', 'i', 'd', 't', 'h', amfNumber, 0x40, 0x9e, 0, 0, 0, 0, 0, 0,
+				0, 0, amfObjectEnd,
+			},
+			want: []interface{}{amfObj{"width": 1920.0}},
+		},
+		{
+			name: "nested object",
+			input: []byte{
+				amfObject,
+				0, 1, 'o', amfObject, 0, 0, amfObjectEnd,
+				0, 0, amfObjectEnd,
+			},
+			want: []interface{}{amfObj{"o": amfObj{}}},
+		},
+		{
+			name: "empty key",
+			input: []byte{
+				amfObject,
+				0, 0, amfBoolean, 1,
+				0, 0, amfObjectEnd,
+			},
+			want: []interface{}{amfObj{"": true}},
+		},
+		{
+			name:  "strict array",
+			input: []byte{amfStrictArray, 0, 0, 0, 2, amfNull, amfBoolean, 1},
+			want:  []interface{}{[]interface{}{nil, true}},
+		},
+		{
+			name:  "date",
+			input: []byte{amfDate, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, amfNull},
+			want:  []interface{}{nil, nil},
+		},
+		{
+			name:  "empty",
+			input: nil,
+		},
+		{
+			name:  "truncated number",
+			input: []byte{amfNumber, 0x40, 0x59},
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "truncated boolean",
+			input: []byte{amfBoolean},
+			err:   io.EOF.Error(),
+		},
+		{
+			name:  "string longer than input",
+			input: []byte{amfString, 0, 10, 'a'},
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "long string longer than input",
+			input: []byte{amfLongString, 0xff, 0xff, 0xff, 0xff, 'a'},
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "truncated string length",
+			input: []byte{amfString, 0},
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "object without end",
+			input: []byte{amfObject, 0, 1, 'a', amfNull},
+			err:   io.EOF.Error(),
+		},
+		{
+			name:  "object with truncated end",
+			input: []byte{amfObject, 0, 0},
+			err:   io.EOF.Error(),
+		},
+		{
+			name:  "strict array shorter than its count",
+			input: []byte{amfStrictArray, 0xff, 0xff, 0xff, 0xff, amfNull},
+			err:   io.EOF.Error(),
+		},
+		{
+			name:  "unsupported type",
+			input: []byte{0x11},
+			err:   "unsupported AMF0 type 0x11",
+		},
+		{
+			name:  "values before an error are kept",
+			input: []byte{amfNull, 0x11},
+			want:  []interface{}{nil},
+			err:   "unsupported AMF0 type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := decodeAMF(tt.input)
+			if tt.err == "" && err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+			if !reflect.DeepEqual(values, tt.want) {
+				t.Fatalf("values = %#v, want %#v", values, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeAMF(t *testing.T) {
+	values := []interface{}{
+		"_result",
+		1,
+		nil,
+		true,
+		amfObj{
+			"code":  "NetConnection.Connect.Success",
+			"level": "status",
+			"info":  amfObj{"n": 2.5},
+		},
+	}
+
+	decoded, err := decodeAMF(encodeAMF(values...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []interface{}{
+		"_result",
+		1.0,
+		nil,
+		true,
+		amfObj{
+			"code":  "NetConnection.Connect.Success",
+			"level": "status",
+			"info":  amfObj{"n": 2.5},
+		},
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("decoded = %#v, want %#v", decoded, want)
+	}
+}
@@ -0,0 +1,299 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	defaultChunkSize = 128
+	maxChunkSize     = 0xffffff
+	extendedTS       = 0xffffff
+)
+
+// chunkLimits bounds the memory a peer can make a connection buffer for
+// messages it has not finished sending.
+type chunkLimits struct {
+	message  uint32
+	buffered int
+	streams  int
+}
+
+var (
+	// Until it is authorized to publish, a peer only needs to send a few
+	// small commands.
+	preAuthLimits = chunkLimits{
+		message:  64 * 1024,
+		buffered: 256 * 1024,
+		streams:  16,
+	}
+	publishLimits = chunkLimits{
+		message:  16 * 1024 * 1024,
+		buffered: 32 * 1024 * 1024,
+		streams:  64,
+	}
+)
+
+type message struct {
+	typeID    byte
+	streamID  uint32
+	timestamp uint32
+	payload   []byte
+}
+
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    byte
+	streamID  uint32
+	extended  bool
+	buf       []byte
+}
+
+// countingReader counts the bytes read from the connection, which is
+// needed to acknowledge them to the peer.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
+
+type chunkReader struct {
+	counter   *countingReader
+	r         *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	limits    chunkLimits
+	buffered  int
+}
+
+func newChunkReader(r io.Reader) *chunkReader {
+	counter := &countingReader{r: r}
+	return &chunkReader{
+		counter:   counter,
+		r:         bufio.NewReader(counter),
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+		limits:    preAuthLimits,
+	}
+}
+
+func (cr *chunkReader) bytesRead() uint64 {
+	return cr.counter.n - uint64(cr.r.Buffered())
+}
+
+func (cr *chunkReader) readUint(n int) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(cr.r, b[4-n:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+func (cr *chunkReader) readMessage() (*message, error) {
+	for {
+		b0, err := cr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		format := b0 >> 6
+		csid := uint32(b0 & 0x3f)
+		switch csid {
+		case 0:
+			b1, err := cr.readUint(1)
+			if err != nil {
+				return nil, err
+			}
+			csid = 64 + b1
+		case 1:
+			b, err := cr.readUint(2)
+			if err != nil {
+				return nil, err
+			}
+			csid = 64 + b>>8 + (b&0xff)*256
+		}
+
+		cs, ok := cr.streams[csid]
+		if !ok {
+			if format != 0 {
+				return nil, fmt.Errorf("rtmp: chunk stream %d starts without a full header", csid)
+			}
+			if len(cr.streams) >= cr.limits.streams {
+				return nil, fmt.Errorf("rtmp: too many chunk streams")
+			}
+			cs = &chunkStream{}
+			cr.streams[csid] = cs
+		}
+
+		var ts uint32
+		if format <= 2 {
+			if ts, err = cr.readUint(3); err != nil {
+				return nil, err
+			}
+		}
+		if format <= 1 {
+			if cs.length, err = cr.readUint(3); err != nil {
+				return nil, err
+			}
+			typeID, err := cr.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			cs.typeID = typeID
+		}
+		if format == 0 {
+			var b [4]byte
+			if _, err := io.ReadFull(cr.r, b[:]); err != nil {
+				return nil, err
+			}
+			cs.streamID = binary.LittleEndian.Uint32(b[:])
+		}
+
+		if format <= 2 {
+			cs.extended = ts == extendedTS
+		}
+		if cs.extended {
+			if ts, err = cr.readUint(4); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(cs.buf) == 0 {
+			switch format {
+			case 0:
+				cs.timestamp = ts
+				cs.delta = 0
+			case 1, 2:
+				cs.delta = ts
+				cs.timestamp += ts
+			case 3:
+				cs.timestamp += cs.delta
+			}
+		}
+
+		if cs.length > cr.limits.message {
+			return nil, fmt.Errorf("rtmp: message of %d bytes is too large", cs.length)
+		}
+		if uint32(len(cs.buf)) > cs.length {
+			// The length was lowered by a new header mid-message.
+			return nil, fmt.Errorf("rtmp: chunk stream %d changed its message length mid-message", csid)
+		}
+
+		size := cs.length - uint32(len(cs.buf))
+		if size > cr.chunkSize {
+			size = cr.chunkSize
+		}
+		if cr.buffered+int(size) > cr.limits.buffered {
+			return nil, fmt.Errorf("rtmp: too many bytes buffered for incomplete messages")
+		}
+
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(cr.r, chunk); err != nil {
+			return nil, err
+		}
+		cs.buf = append(cs.buf, chunk...)
+		cr.buffered += len(chunk)
+
+		if uint32(len(cs.buf)) == cs.length {
+			cr.buffered -= len(cs.buf)
+			msg := &message{
+				typeID:    cs.typeID,
+				streamID:  cs.streamID,
+				timestamp: cs.timestamp,
+				payload:   cs.buf,
+			}
+			cs.buf = nil
+			return msg, nil
+		}
+	}
+}
+
+// abort discards the incomplete message on a chunk stream.
+func (cr *chunkReader) abort(csid uint32) {
+	if cs, ok := cr.streams[csid]; ok {
+		cr.buffered -= len(cs.buf)
+		cs.buf = nil
+	}
+}
+
+type chunkWriter struct {
+	w         *bufio.Writer
+	chunkSize uint32
+}
+
+func newChunkWriter(w io.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:         bufio.NewWriter(w),
+		chunkSize: defaultChunkSize,
+	}
+}
+
+// writeMessage writes a message on a chunk stream with an ID below 64,
+// which is all the server needs.
+func (cw *chunkWriter) writeMessage(csid uint32, msg *message) error {
+	header := make([]byte, 12)
+	header[0] = byte(csid & 0x3f)
+
+	ts := msg.timestamp
+	if ts >= extendedTS {
+		ts = extendedTS
+	}
+	header[1] = byte(ts >> 16)
+	header[2] = byte(ts >> 8)
+	header[3] = byte(ts)
+
+	length := len(msg.payload)
+	header[4] = byte(length >> 16)
+	header[5] = byte(length >> 8)
+	header[6] = byte(length)
+	header[7] = msg.typeID
+	binary.LittleEndian.PutUint32(header[8:], msg.streamID)
+
+	if _, err := cw.w.Write(header); err != nil {
+		return err
+	}
+
+	var ext []byte
+	if ts == extendedTS {
+		ext = make([]byte, 4)
+		binary.BigEndian.PutUint32(ext, msg.timestamp)
+		if _, err := cw.w.Write(ext); err != nil {
+			return err
+		}
+	}
+
+	payload := msg.payload
+	for {
+		size := len(payload)
+		if size > int(cw.chunkSize) {
+			size = int(cw.chunkSize)
+		}
+		if _, err := cw.w.Write(payload[:size]); err != nil {
+			return err
+		}
+		payload = payload[size:]
+		if len(payload) == 0 {
+			break
+		}
+
+		if err := cw.w.WriteByte(0xc0 | byte(csid&0x3f)); err != nil {
+			return err
+		}
+		if ext != nil {
+			if _, err := cw.w.Write(ext); err != nil {
+				return err
+			}
+		}
+	}
+
+	return cw.w.Flush()
+}
@@ -0,0 +1,298 @@
+package rtmp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chunk returns a chunk on a chunk stream with an ID below 64. The header
+// fields that format does not carry are left out.
+func chunk(format byte, csid uint32, ts uint32, length int, typeID byte, streamID uint32, payload []byte) []byte {
+	b := []byte{format<<6 | byte(csid)}
+	if format <= 2 {
+		b = append(b, byte(ts>>16), byte(ts>>8), byte(ts))
+	}
+	if format <= 1 {
+		b = append(b, byte(length>>16), byte(length>>8), byte(length), typeID)
+	}
+	if format == 0 {
+		var id [4]byte
+		binary.LittleEndian.PutUint32(id[:], streamID)
+		b = append(b, id[:]...)
+	}
+	return append(b, payload...)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestReadMessage(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 200)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []message
+		err   string
+	}{
+		{
+			name:  "single chunk",
+			input: chunk(0, 3, 1000, 3, msgCommandAMF0, 1, []byte("abc")),
+			want:  []message{{typeID: msgCommandAMF0, streamID: 1, timestamp: 1000, payload: []byte("abc")}},
+		},
+		{
+			name: "message split across chunks",
+			input: join(
+				chunk(0, 4, 0, len(long), msgVideo, 1, long[:defaultChunkSize]),
+				chunk(3, 4, 0, 0, 0, 0, long[defaultChunkSize:]),
+			),
+			want: []message{{typeID: msgVideo, streamID: 1, payload: long}},
+		},
+		{
+			name: "interleaved chunk streams",
+			input: join(
+				chunk(0, 4, 0, len(long), msgVideo, 1, long[:defaultChunkSize]),
+				chunk(0, 5, 10, 2, msgAudio, 1, []byte("au")),
+				chunk(3, 4, 0, 0, 0, 0, long[defaultChunkSize:]),
+			),
+			want: []message{
+				{typeID: msgAudio, streamID: 1, timestamp: 10, payload: []byte("au")},
+				{typeID: msgVideo, streamID: 1, payload: long},
+			},
+		},
+		{
+			name: "timestamp deltas",
+			input: join(
+				chunk(0, 4, 100, 1, msgVideo, 1, []byte("a")),
+				chunk(1, 4, 40, 2, msgVideo, 0, []byte("bb")),
+				chunk(2, 4, 30, 0, 0, 0, []byte("cc")),
+				chunk(3, 4, 0, 0, 0, 0, []byte("dd")),
+			),
+			want: []message{
+				{typeID: msgVideo, streamID: 1, timestamp: 100, payload: []byte("a")},
+				{typeID: msgVideo, streamID: 1, timestamp: 140, payload: []byte("bb")},
+				{typeID: msgVideo, streamID: 1, timestamp: 170, payload: []byte("cc")},
+				{typeID: msgVideo, streamID: 1, timestamp: 200, payload: []byte("dd")},
+			},
+		},
+		{
+			name: "extended timestamp",
+			input: join(
+				chunk(0, 3, extendedTS, 1, msgVideo, 1, nil),
+				[]byte{0x01, 0x00, 0x00, 0x00, 'a'},
+			),
+			want: []message{{typeID: msgVideo, streamID: 1, timestamp: 0x01000000, payload: []byte("a")}},
+		},
+		{
+			name:  "two byte chunk stream ID",
+			input: append([]byte{0x00, 10}, chunk(0, 0, 0, 1, msgAudio, 1, []byte("a"))[1:]...),
+			want:  []message{{typeID: msgAudio, streamID: 1, payload: []byte("a")}},
+		},
+		{
+			name:  "three byte chunk stream ID",
+			input: append([]byte{0x01, 10, 1}, chunk(0, 0, 0, 1, msgAudio, 1, []byte("a"))[1:]...),
+			want:  []message{{typeID: msgAudio, streamID: 1, payload: []byte("a")}},
+		},
+		{
+			name:  "empty message",
+			input: chunk(0, 3, 0, 0, msgUserControl, 0, nil),
+			want:  []message{{typeID: msgUserControl}},
+		},
+		{
+			name:  "no input",
+			input: nil,
+			err:   io.EOF.Error(),
+		},
+		{
+			name:  "first chunk without full header",
+			input: chunk(1, 3, 0, 1, msgAudio, 0, []byte("a")),
+			err:   "starts without a full header",
+		},
+		{
+			name:  "truncated chunk stream ID",
+			input: []byte{0x01, 10},
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "truncated header",
+			input: chunk(0, 3, 0, 1, msgAudio, 1, nil)[:6],
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "truncated extended timestamp",
+			input: join(chunk(0, 3, extendedTS, 1, msgVideo, 1, nil), []byte{0x01}),
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "truncated payload",
+			input: chunk(0, 3, 0, 10, msgAudio, 1, []byte("abc")),
+			err:   io.ErrUnexpectedEOF.Error(),
+		},
+		{
+			name:  "truncated between chunks",
+			input: chunk(0, 4, 0, len(long), msgVideo, 1, long[:defaultChunkSize]),
+			err:   io.EOF.Error(),
+		},
+		{
+			name: "length lowered mid-message",
+			input: join(
+				chunk(0, 4, 0, len(long), msgVideo, 1, long[:defaultChunkSize]),
+				chunk(1, 4, 0, 10, msgVideo, 0, nil),
+			),
+			err: "changed its message length",
+		},
+		{
+			name:  "message too large before publish",
+			input: chunk(0, 3, 0, int(preAuthLimits.message)+1, msgVideo, 1, nil),
+			err:   "too large",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newChunkReader(bytes.NewReader(tt.input))
+			for i, want := range tt.want {
+				msg, err := cr.readMessage()
+				if err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+				if !reflect.DeepEqual(*msg, want) {
+					t.Fatalf("message %d = %+v, want %+v", i, *msg, want)
+				}
+			}
+
+			_, err := cr.readMessage()
+			if tt.err == "" {
+				tt.err = io.EOF.Error()
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadMessageLimits(t *testing.T) {
+	big := int(preAuthLimits.message)
+
+	tests := []struct {
+		name      string
+		limits    chunkLimits
+		chunkSize uint32
+		input     func() []byte
+		err       string
+	}{
+		{
+			name:      "too many chunk streams",
+			limits:    preAuthLimits,
+			chunkSize: 1,
+			input: func() []byte {
+				var b []byte
+				for csid := uint32(3); csid < uint32(3+preAuthLimits.streams+1); csid++ {
+					b = append(b, chunk(0, csid, 0, 2, msgAudio, 1, []byte("a"))...)
+				}
+				return b
+			},
+			err: "too many chunk streams",
+		},
+		{
+			name:      "too many bytes buffered",
+			limits:    preAuthLimits,
+			chunkSize: uint32(big - 1),
+			input: func() []byte {
+				var b []byte
+				for csid := uint32(3); csid < uint32(3+preAuthLimits.streams); csid++ {
+					b = append(b, chunk(0, csid, 0, big, msgVideo, 1, make([]byte, big-1))...)
+				}
+				return b
+			},
+			err: "too many bytes buffered",
+		},
+		{
+			name:      "large message after publish",
+			limits:    publishLimits,
+			chunkSize: maxChunkSize,
+			input: func() []byte {
+				return chunk(0, 4, 0, 4*big, msgVideo, 1, make([]byte, 4*big))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newChunkReader(bytes.NewReader(tt.input()))
+			cr.chunkSize = tt.chunkSize
+			cr.limits = tt.limits
+
+			var err error
+			for err == nil {
+				_, err = cr.readMessage()
+			}
+			if tt.err == "" {
+				if err != io.EOF {
+					t.Fatalf("error = %v, want EOF", err)
+				}
+				return
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestChunkReaderAbort(t *testing.T) {
+	input := join(
+		chunk(0, 4, 0, 1000, msgVideo, 1, make([]byte, defaultChunkSize)),
+		chunk(0, 5, 0, 1, msgAudio, 1, []byte("a")),
+	)
+	cr := newChunkReader(bytes.NewReader(input))
+
+	if _, err := cr.readMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if cr.buffered != defaultChunkSize {
+		t.Fatalf("buffered = %d, want %d", cr.buffered, defaultChunkSize)
+	}
+
+	cr.abort(4)
+	if cr.buffered != 0 {
+		t.Fatalf("buffered = %d after abort, want 0", cr.buffered)
+	}
+	if len(cr.streams[4].buf) != 0 {
+		t.Fatal("incomplete message was kept after abort")
+	}
+}
+
+func TestChunkWriterRoundTrip(t *testing.T) {
+	messages := []*message{
+		{typeID: msgCommandAMF0, streamID: 0, timestamp: 0, payload: []byte("short")},
+		{typeID: msgVideo, streamID: 1, timestamp: 1234, payload: bytes.Repeat([]byte{1, 2, 3}, 1000)},
+		{typeID: msgAudio, streamID: 1, timestamp: 0x01020304, payload: bytes.Repeat([]byte{4}, 300)},
+	}
+
+	buf := &bytes.Buffer{}
+	cw := newChunkWriter(buf)
+	for _, msg := range messages {
+		if err := cw.writeMessage(csidCommand, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cr := newChunkReader(buf)
+	for i, want := range messages {
+		msg, err := cr.readMessage()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if msg.typeID != want.typeID || msg.streamID != want.streamID || msg.timestamp != want.timestamp ||
+			!bytes.Equal(msg.payload, want.payload) {
+			t.Fatalf("message %d = %+v, want %+v", i, msg, want)
+		}
+	}
+}
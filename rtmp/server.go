@@ -0,0 +1,558 @@
+// Package rtmp implements a minimal RTMP server that accepts a single
+// authenticated publisher and exposes the published stream as FLV.
+package rtmp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sbekti/broadcastd/flv"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	handshakeSize   = 1536
+	rtmpVersion     = 3
+	serverChunk     = 4096
+	windowAckSize   = 2500000
+	readTimeout     = 30 * time.Second
+	bitrateWindow   = 2 * time.Second
+	publishStreamID = 1
+
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAck              = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+
+	csidControl = 2
+	csidCommand = 3
+
+	setDataFrame = "@setDataFrame"
+)
+
+var ErrServerClosed = errors.New("rtmp: server closed")
+
+// State describes the current publisher of a Server.
+type State struct {
+	Publishing    bool      `json:"publishing"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	Since         time.Time `json:"since"`
+	VideoCodec    string    `json:"video_codec,omitempty"`
+	AudioCodec    string    `json:"audio_codec,omitempty"`
+	Width         int       `json:"width,omitempty"`
+	Height        int       `json:"height,omitempty"`
+	FrameRate     float64   `json:"frame_rate,omitempty"`
+	Bitrate       int       `json:"bitrate"`
+	BytesReceived int64     `json:"bytes_received"`
+}
+
+type Server struct {
+	Addr      string
+	App       string
+	StreamKey string
+
+	hub *flv.Hub
+
+	mux         sync.Mutex
+	listener    net.Listener
+	conns       map[net.Conn]struct{}
+	closed      bool
+	publisher   *conn
+	state       State
+	windowStart time.Time
+	windowBytes int64
+}
+
+// NewServer returns a server that accepts publishes to
+// rtmp://addr/app/streamKey.
+func NewServer(addr string, app string, streamKey string) *Server {
+	return &Server{
+		Addr:      addr,
+		App:       app,
+		StreamKey: streamKey,
+		hub:       flv.NewHub(),
+		conns:     make(map[net.Conn]struct{}),
+		state:     State{Since: time.Now()},
+	}
+}
+
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	if s.closed {
+		s.mux.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mux.Unlock()
+
+	log.Infof("rtmp: listening on %s", l.Addr())
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mux.Lock()
+			closed := s.closed
+			s.mux.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mux.Lock()
+		s.conns[nc] = struct{}{}
+		s.mux.Unlock()
+
+		go s.serve(nc)
+	}
+}
+
+// Close stops listening and disconnects every client.
+func (s *Server) Close() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.closed = true
+	for nc := range s.conns {
+		nc.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) State() State {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	state := s.state
+	if state.Publishing && time.Since(s.windowStart) > 2*bitrateWindow {
+		// Nothing has been received for a while.
+		state.Bitrate = 0
+	}
+	return state
+}
+
+// Subscribe returns a subscription to the published stream. It receives
+// data from the current or next publish, and is closed when it ends.
+func (s *Server) Subscribe() *flv.Subscription {
+	return s.hub.Subscribe()
+}
+
+func (s *Server) Unsubscribe(sub *flv.Subscription) {
+	s.hub.Unsubscribe(sub)
+}
+
+func (s *Server) serve(nc net.Conn) {
+	c := &conn{
+		server:  s,
+		netConn: nc,
+		reader:  newChunkReader(nc),
+		writer:  newChunkWriter(nc),
+	}
+
+	err := c.serve()
+
+	s.mux.Lock()
+	closed := s.closed
+	s.mux.Unlock()
+
+	if err != nil && err != io.EOF && !closed {
+		log.Warnf("rtmp: %s: %v", nc.RemoteAddr(), err)
+	}
+
+	s.endPublish(c)
+
+	s.mux.Lock()
+	delete(s.conns, nc)
+	s.mux.Unlock()
+	nc.Close()
+}
+
+func (s *Server) authorize(app string, streamName string) bool {
+	// Clients may append a query string to either part.
+	app = strings.Trim(strings.SplitN(app, "?", 2)[0], "/")
+	streamName = strings.SplitN(streamName, "?", 2)[0]
+
+	if s.App != "" && app != s.App {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(streamName), []byte(s.StreamKey)) == 1
+}
+
+func (s *Server) startPublish(c *conn) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.publisher != nil {
+		return fmt.Errorf("rtmp: stream is already being published from %s", s.publisher.netConn.RemoteAddr())
+	}
+
+	s.publisher = c
+	s.state = State{
+		Publishing: true,
+		RemoteAddr: c.netConn.RemoteAddr().String(),
+		Since:      time.Now(),
+	}
+	s.windowStart = time.Now()
+	s.windowBytes = 0
+
+	log.Infof("rtmp: %s: started publishing", c.netConn.RemoteAddr())
+	return nil
+}
+
+func (s *Server) endPublish(c *conn) {
+	s.mux.Lock()
+	if s.publisher != c {
+		s.mux.Unlock()
+		return
+	}
+	s.publisher = nil
+	s.state = State{Since: time.Now()}
+	s.mux.Unlock()
+
+	s.hub.Reset()
+	log.Infof("rtmp: %s: stopped publishing", c.netConn.RemoteAddr())
+}
+
+func (s *Server) isPublisher(c *conn) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.publisher == c
+}
+
+func (s *Server) updateState(update func(state *State), size int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if update != nil {
+		update(&s.state)
+	}
+
+	s.state.BytesReceived += int64(size)
+	s.windowBytes += int64(size)
+	if elapsed := time.Since(s.windowStart); elapsed >= bitrateWindow {
+		s.state.Bitrate = int(float64(s.windowBytes*8) / elapsed.Seconds() / 1000)
+		s.windowStart = time.Now()
+		s.windowBytes = 0
+	}
+}
+
+type conn struct {
+	server     *Server
+	netConn    net.Conn
+	reader     *chunkReader
+	writer     *chunkWriter
+	app        string
+	ackWindow  uint32
+	lastAck    uint64
+	headerSent bool
+	metadata   amfObj
+}
+
+func (c *conn) serve() error {
+	c.netConn.SetDeadline(time.Now().Add(readTimeout))
+	if err := c.handshake(); err != nil {
+		return fmt.Errorf("handshake: %v", err)
+	}
+	c.netConn.SetDeadline(time.Time{})
+
+	for {
+		c.netConn.SetReadDeadline(time.Now().Add(readTimeout))
+		msg, err := c.reader.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if err := c.handleMessage(msg); err != nil {
+			return err
+		}
+
+		if err := c.acknowledge(); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *conn) handshake() error {
+	c0c1 := make([]byte, 1+handshakeSize)
+	if _, err := io.ReadFull(c.netConn, c0c1); err != nil {
+		return err
+	}
+	if c0c1[0] != rtmpVersion {
+		return fmt.Errorf("unsupported RTMP version %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*handshakeSize)
+	s0s1s2[0] = rtmpVersion
+	s1 := s0s1s2[1 : 1+handshakeSize]
+	binary.BigEndian.PutUint32(s1, uint32(time.Now().Unix()))
+	if _, err := rand.Read(s1[8:]); err != nil {
+		return err
+	}
+	copy(s0s1s2[1+handshakeSize:], c0c1[1:])
+
+	if _, err := c.netConn.Write(s0s1s2); err != nil {
+		return err
+	}
+
+	c2 := make([]byte, handshakeSize)
+	_, err := io.ReadFull(c.netConn, c2)
+	return err
+}
+
+func (c *conn) acknowledge() error {
+	if c.ackWindow == 0 {
+		return nil
+	}
+
+	read := c.reader.bytesRead()
+	if read-c.lastAck < uint64(c.ackWindow) {
+		return nil
+	}
+	c.lastAck = read
+
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(read))
+	return c.writeControl(msgAck, payload)
+}
+
+func (c *conn) handleMessage(msg *message) error {
+	switch msg.typeID {
+	case msgSetChunkSize:
+		if len(msg.payload) < 4 {
+			return fmt.Errorf("invalid set chunk size message")
+		}
+		size := binary.BigEndian.Uint32(msg.payload) & 0x7fffffff
+		if size == 0 || size > maxChunkSize {
+			return fmt.Errorf("invalid chunk size %d", size)
+		}
+		c.reader.chunkSize = size
+	case msgWindowAckSize:
+		if len(msg.payload) >= 4 {
+			c.ackWindow = binary.BigEndian.Uint32(msg.payload)
+		}
+	case msgCommandAMF0:
+		return c.handleCommand(msg)
+	case msgCommandAMF3:
+		if len(msg.payload) > 0 {
+			msg.payload = msg.payload[1:]
+		}
+		return c.handleCommand(msg)
+	case msgDataAMF0, msgDataAMF3, msgAudio, msgVideo:
+		if c.server.isPublisher(c) {
+			c.handleMedia(msg)
+		}
+	case msgAbort:
+		if len(msg.payload) >= 4 {
+			c.reader.abort(binary.BigEndian.Uint32(msg.payload))
+		}
+	case msgAck, msgUserControl, msgSetPeerBandwidth:
+	default:
+		log.Debugf("rtmp: %s: ignoring message type %d", c.netConn.RemoteAddr(), msg.typeID)
+	}
+	return nil
+}
+
+func (c *conn) handleCommand(msg *message) error {
+	values, err := decodeAMF(msg.payload)
+	if err != nil {
+		return fmt.Errorf("invalid command: %v", err)
+	}
+	if len(values) < 2 {
+		return fmt.Errorf("invalid command: too few values")
+	}
+
+	name, _ := values[0].(string)
+	txn, _ := values[1].(float64)
+	log.Debugf("rtmp: %s: command %s", c.netConn.RemoteAddr(), name)
+
+	switch name {
+	case "connect":
+		if len(values) > 2 {
+			if obj, ok := values[2].(amfObj); ok {
+				c.app = obj.str("app")
+			}
+		}
+		return c.onConnect(txn)
+	case "createStream":
+		return c.writeCommand(0, "_result", txn, nil, publishStreamID)
+	case "releaseStream", "FCPublish", "FCUnpublish", "getStreamLength":
+		return c.writeCommand(0, "_result", txn, nil)
+	case "publish":
+		streamName := ""
+		if len(values) > 3 {
+			streamName, _ = values[3].(string)
+		}
+		return c.onPublish(msg.streamID, streamName)
+	case "deleteStream", "closeStream":
+		c.server.endPublish(c)
+		c.reader.limits = preAuthLimits
+		// The encoder may publish again on this connection, which starts
+		// a new stream with its own header and metadata.
+		c.headerSent = false
+		c.metadata = nil
+	}
+	return nil
+}
+
+func (c *conn) onConnect(txn float64) error {
+	ack := make([]byte, 4)
+	binary.BigEndian.PutUint32(ack, windowAckSize)
+	if err := c.writeControl(msgWindowAckSize, ack); err != nil {
+		return err
+	}
+
+	bandwidth := make([]byte, 5)
+	binary.BigEndian.PutUint32(bandwidth, windowAckSize)
+	bandwidth[4] = 2 // dynamic
+	if err := c.writeControl(msgSetPeerBandwidth, bandwidth); err != nil {
+		return err
+	}
+
+	chunkSize := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunkSize, serverChunk)
+	if err := c.writeControl(msgSetChunkSize, chunkSize); err != nil {
+		return err
+	}
+	c.writer.chunkSize = serverChunk
+
+	return c.writeCommand(0, "_result", txn,
+		amfObj{
+			"fmsVer":       "FMS/3,0,1,123",
+			"capabilities": 31,
+		},
+		amfObj{
+			"level":          "status",
+			"code":           "NetConnection.Connect.Success",
+			"description":    "Connection succeeded.",
+			"objectEncoding": 0,
+		},
+	)
+}
+
+func (c *conn) onPublish(streamID uint32, streamName string) error {
+	if !c.server.authorize(c.app, streamName) {
+		_ = c.writeStatus(streamID, "error", "NetStream.Publish.BadName", "Invalid stream key.")
+		return fmt.Errorf("rejected publish to %s with an invalid stream key", c.app)
+	}
+
+	if err := c.server.startPublish(c); err != nil {
+		_ = c.writeStatus(streamID, "error", "NetStream.Publish.BadName", "Stream is already being published.")
+		return err
+	}
+	c.reader.limits = publishLimits
+
+	return c.writeStatus(streamID, "status", "NetStream.Publish.Start", "Start publishing.")
+}
+
+func (c *conn) handleMedia(msg *message) {
+	var tagType byte
+	payload := msg.payload
+	var update func(state *State)
+
+	switch msg.typeID {
+	case msgAudio:
+		tagType = flv.TagAudio
+		if len(payload) > 0 {
+			codec := flv.AudioCodecName(payload)
+			update = func(state *State) { state.AudioCodec = codec }
+		}
+	case msgVideo:
+		tagType = flv.TagVideo
+		if len(payload) > 0 {
+			codec := flv.VideoCodecName(payload)
+			update = func(state *State) { state.VideoCodec = codec }
+		}
+	default:
+		if msg.typeID == msgDataAMF3 && len(payload) > 0 {
+			payload = payload[1:]
+		}
+
+		values, err := decodeAMF(payload)
+		if err != nil || len(values) == 0 {
+			return
+		}
+		if name, _ := values[0].(string); name == setDataFrame {
+			// Strip "@setDataFrame" so that the tag starts with "onMetaData".
+			payload = payload[3+len(setDataFrame):]
+			values = values[1:]
+		}
+		if len(values) < 2 {
+			return
+		}
+		if metadata, ok := values[1].(amfObj); ok {
+			c.metadata = metadata
+			update = func(state *State) {
+				state.Width = int(metadata.num("width"))
+				state.Height = int(metadata.num("height"))
+				state.FrameRate = metadata.num("framerate")
+			}
+		}
+		tagType = flv.TagScript
+	}
+
+	if !c.headerSent {
+		c.server.hub.SetHeader(c.flvHeader(tagType))
+		c.headerSent = true
+	}
+
+	c.server.updateState(update, len(payload))
+	c.server.hub.Publish(flv.NewTag(tagType, msg.timestamp, payload))
+}
+
+// flvHeader guesses which tracks the stream has from its metadata, or
+// from the first media message if there is none.
+func (c *conn) flvHeader(firstTag byte) []byte {
+	if c.metadata != nil {
+		_, audio := c.metadata["audiocodecid"]
+		_, video := c.metadata["videocodecid"]
+		return flv.NewHeader(audio, video)
+	}
+	return flv.NewHeader(true, firstTag != flv.TagAudio)
+}
+
+func (c *conn) writeControl(typeID byte, payload []byte) error {
+	return c.writer.writeMessage(csidControl, &message{
+		typeID:  typeID,
+		payload: payload,
+	})
+}
+
+func (c *conn) writeCommand(streamID uint32, values ...interface{}) error {
+	return c.writer.writeMessage(csidCommand, &message{
+		typeID:   msgCommandAMF0,
+		streamID: streamID,
+		payload:  encodeAMF(values...),
+	})
+}
+
+func (c *conn) writeStatus(streamID uint32, level string, code string, description string) error {
+	return c.writeCommand(streamID, "onStatus", 0, nil, amfObj{
+		"level":       level,
+		"code":        code,
+		"description": description,
+	})
+}
@@ -0,0 +1,164 @@
+package rtmp
+
+import (
+	"bytes"
+	"github.com/sbekti/broadcastd/flv"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	c1 := bytes.Repeat([]byte{0xab}, handshakeSize)
+
+	tests := []struct {
+		name    string
+		client  []byte
+		wantErr bool
+	}{
+		{
+			name:   "valid",
+			client: join([]byte{rtmpVersion}, c1, make([]byte, handshakeSize)),
+		},
+		{
+			name:    "unsupported version",
+			client:  join([]byte{6}, c1),
+			wantErr: true,
+		},
+		{
+			name:    "truncated C1",
+			client:  join([]byte{rtmpVersion}, c1[:100]),
+			wantErr: true,
+		},
+		{
+			name:    "truncated C2",
+			client:  join([]byte{rtmpVersion}, c1, make([]byte, 100)),
+			wantErr: true,
+		},
+		{
+			name:    "no input",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer server.Close()
+
+			// S0, S1 and S2 are read concurrently since the pipe is
+			// unbuffered.
+			reply := make(chan []byte, 1)
+			go func() {
+				defer client.Close()
+				go func() {
+					b, _ := ioutil.ReadAll(client)
+					reply <- b
+				}()
+				client.Write(tt.client)
+			}()
+
+			c := &conn{netConn: server}
+			err := c.handshake()
+			server.Close()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("handshake succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := <-reply
+			if len(s) != 1+2*handshakeSize {
+				t.Fatalf("server sent %d bytes, want %d", len(s), 1+2*handshakeSize)
+			}
+			if s[0] != rtmpVersion {
+				t.Errorf("S0 = %d, want %d", s[0], rtmpVersion)
+			}
+			if !bytes.Equal(s[1+handshakeSize:], c1) {
+				t.Error("S2 does not echo C1")
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		name       string
+		app        string
+		streamKey  string
+		appArg     string
+		streamName string
+		want       bool
+	}{
+		{"matching", "live", "secret", "live", "secret", true},
+		{"query strings", "live", "secret", "live?a=1", "secret?b=2", true},
+		{"slashes around app", "live", "secret", "/live/", "secret", true},
+		{"any app", "", "secret", "other", "secret", true},
+		{"wrong key", "live", "secret", "live", "secreT", false},
+		{"key prefix", "live", "secret", "live", "secre", false},
+		{"wrong app", "live", "secret", "other", "secret", false},
+		{"empty key", "live", "secret", "live", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("", tt.app, tt.streamKey)
+			if got := s.authorize(tt.appArg, tt.streamName); got != tt.want {
+				t.Fatalf("authorize(%q, %q) = %t, want %t", tt.appArg, tt.streamName, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepublishOnSameConnection(t *testing.T) {
+	s := NewServer("", "live", "secret")
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, client)
+
+	c := &conn{
+		server:  s,
+		netConn: server,
+		reader:  newChunkReader(server),
+		writer:  newChunkWriter(server),
+		app:     "live",
+	}
+	command := func(values ...interface{}) {
+		t.Helper()
+		msg := &message{typeID: msgCommandAMF0, streamID: publishStreamID, payload: encodeAMF(values...)}
+		if err := c.handleCommand(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metadata := func(obj amfObj) *message {
+		return &message{typeID: msgDataAMF0, streamID: publishStreamID, payload: encodeAMF(setDataFrame, "onMetaData", obj)}
+	}
+
+	command("publish", 0.0, nil, "secret")
+	c.handleMedia(metadata(amfObj{"audiocodecid": 10.0}))
+	c.handleMedia(&message{typeID: msgAudio, payload: []byte{0xaf, 0}})
+	command("deleteStream", 0.0, nil, float64(publishStreamID))
+	if s.State().Publishing {
+		t.Fatal("still publishing after deleteStream")
+	}
+
+	command("publish", 0.0, nil, "secret")
+	sub := s.Subscribe()
+	c.handleMedia(metadata(amfObj{"videocodecid": 7.0}))
+	c.handleMedia(&message{typeID: msgVideo, payload: []byte{0x17, 0}})
+	s.Unsubscribe(sub)
+
+	var b bytes.Buffer
+	if _, err := sub.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if header := flv.NewHeader(false, true); !bytes.HasPrefix(b.Bytes(), header) {
+		t.Fatalf("second publish starts with % x, want the video-only header % x", b.Bytes(), header)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/sbekti/broadcastd/broadcast"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const statusTimeout = 10 * time.Second
+
+type statusRes struct {
+	Live     bool                   `json:"live"`
+	AutoLive bool                   `json:"auto_live"`
+	Streams  []broadcast.StreamInfo `json:"streams"`
+}
+
+// status prints the status of the streams of a running daemon, found at
+// the address in the config file unless -url is given.
+func status(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	configPath := fs.String("c", defaultConfig, "path to config file")
+	baseURL := fs.String("url", "", "base URL of the daemon (default: from the config file)")
+	apiToken := fs.String("token", "", "API token (default: the first of auth.api_tokens in the config file)")
+	printJSON := fs.Bool("json", false, "print the status as JSON")
+	fs.Parse(args)
+
+	if *baseURL == "" || *apiToken == "" {
+		// Only the address and the API tokens are needed, so other
+		// problems in the config are ignored. Without -url the config
+		// file is required.
+		c, err := broadcast.LoadConfig(*configPath)
+		if _, ok := err.(*broadcast.ValidationError); ok {
+			err = nil
+		}
+		if err != nil && *baseURL == "" {
+			fatalf("%v", err)
+		}
+		if err == nil {
+			if *baseURL == "" {
+				host := c.BindIP
+				if host == "" || host == "0.0.0.0" || host == "::" {
+					host = "127.0.0.1"
+				}
+				*baseURL = "http://" + net.JoinHostPort(host, strconv.Itoa(c.BindPort))
+			}
+			if *apiToken == "" && c.Auth.Enabled && len(c.Auth.APITokens) > 0 {
+				*apiToken = c.Auth.APITokens[0]
+			}
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(*baseURL, "/")+"/api/v1/status", nil)
+	if err != nil {
+		fatalf("status: %v", err)
+	}
+	if *apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*apiToken)
+	}
+
+	client := &http.Client{Timeout: statusTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		fatalf("status: unable to reach broadcastd: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		fatalf("status: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		fatalf("status: broadcastd returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if *printJSON {
+		os.Stdout.Write(body)
+		fmt.Println()
+		return
+	}
+
+	var res statusRes
+	if err := json.Unmarshal(body, &res); err != nil {
+		fatalf("status: unable to decode response: %v", err)
+	}
+
+	fmt.Printf("live: %t\nauto live: %t\n\n", res.Live, res.AutoLive)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSTATUS\tLIVE\tVIEWERS\tUPTIME\tLAST ERROR")
+	for _, s := range res.Streams {
+		uptime := "-"
+		if s.StartTime != nil {
+			uptime = time.Since(*s.StartTime).Round(time.Second).String()
+		}
+		lastError := s.State.LastError
+		if lastError == "" {
+			lastError = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%d\t%s\t%s\n",
+			s.Name, s.Type, s.Status, s.Live, s.ViewerCount, uptime, lastError)
+	}
+	w.Flush()
+}